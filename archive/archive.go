@@ -0,0 +1,84 @@
+// Package archive 把多个文件打包为 zip 或 tar.gz.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Writer 依次写入文件，最后必须调用 Close.
+type Writer interface {
+	Add(name string, modTime time.Time, data []byte) error
+	Close() error
+}
+
+// Formats 支持的格式与对应的 Content-Type.
+var Formats = map[string]string{
+	"zip":    "application/zip",
+	"tar.gz": "application/gzip",
+}
+
+// NewWriter 根据 format ("zip" 或 "tar.gz") 创建 Writer.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case "zip":
+		return &zipWriter{zip.NewWriter(w)}, nil
+	case "tar.gz":
+		gz := gzip.NewWriter(w)
+		return &tarGzWriter{gz, tar.NewWriter(gz)}, nil
+	}
+	return nil, fmt.Errorf("unknown archive format: %s", format)
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipWriter) Add(name string, modTime time.Time, data []byte) error {
+	f, err := w.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+type tarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (w *tarGzWriter) Add(name string, modTime time.Time, data []byte) error {
+	err := w.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.tw.Write(data)
+	return err
+}
+
+func (w *tarGzWriter) Close() error {
+	err1 := w.tw.Close()
+	err2 := w.gz.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/ahui2016/uglynotes/database"
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+// auditLimit 查询审计日志时默认返回的条数上限
+const auditLimit = 100
+
+// audit 在修改操作成功后记录一条审计日志。
+// 审计日志写入失败时只打印日志，不影响已经完成的操作。
+func audit(c *fiber.Ctx, op string, targets []string, before, after string) {
+	deferredAudit(c, op, targets)(before, after)
+}
+
+// deferredAudit 返回一个记录审计日志的函数，用于 handler 返回之后才完成的操作
+// (例如流式发送的压缩包)，此时已不能使用 c.
+func deferredAudit(c *fiber.Ctx, op string, targets []string) func(before, after string) {
+	session, ip := db.SessionLabel(c), c.IP()
+	return func(before, after string) {
+		entry := model.NewAuditEntry(op, targets, before, after)
+		entry.Session = session
+		entry.IP = ip
+		if err := db.AddAudit(entry); err != nil {
+			log.Printf("audit %s %v: %v", op, targets, err)
+		}
+	}
+}
+
+// auditNote 记录对一篇笔记的修改，before 是修改前的笔记（新建笔记时为 nil）,
+// 修改后的笔记从数据库重新读取（永久删除后读取不到，此时 after 为空）。
+func auditNote(c *fiber.Ctx, op string, id string, before *Note) {
+	var beforeSummary, afterSummary string
+	if before != nil {
+		beforeSummary = noteSummary(before)
+	}
+	if after, err := db.GetByID(id); err == nil {
+		afterSummary = noteSummary(&after)
+	}
+	audit(c, op, []string{id}, beforeSummary, afterSummary)
+}
+
+// auditRenamedLinks 记录因笔记 id 的标题改变而修改了 [[旧标题]] 链接的其他笔记。
+func auditRenamedLinks(c *fiber.Ctx, id string, renamed database.RenamedLinks) {
+	if len(renamed.Updated) > 0 {
+		audit(c, model.OpNotePatch, renamed.Updated, "", "renamed links to "+id)
+	}
+}
+
+// noteSummary 返回笔记的简要描述，启用加密时不包含标题。
+func noteSummary(note *Note) string {
+	title := note.Title
+	if db.Encrypted() {
+		title = "(encrypted)"
+	}
+	return fmt.Sprintf("title=%q type=%s tags=%v versions=%d size=%d deleted=%t",
+		title, note.Type, note.Tags, len(note.Patches), note.Size, note.Deleted)
+}
+
+func getAuditFilter(c *fiber.Ctx) (filter database.AuditFilter, err error) {
+	filter = database.AuditFilter{
+		Operation: c.Query("op"),
+		Target:    c.Query("target"),
+		Since:     c.Query("since"),
+		Until:     c.Query("until"),
+		Limit:     auditLimit,
+	}
+	if limit := c.Query("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+	}
+	return
+}
+
+// getAudit 查询审计日志，可用参数: op, target, since, until, limit (0 表示不限)。
+func getAudit(c *fiber.Ctx) error {
+	filter, err := getAuditFilter(c)
+	if err != nil {
+		return err
+	}
+	entries, err := db.FindAudit(filter)
+	if err != nil {
+		return err
+	}
+	return c.JSON(entries)
+}
+
+// exportAudit 以 JSONL 格式 (每行一条) 导出审计日志，参数与 getAudit 相同，
+// 但 limit 默认为 0 (不限)。
+func exportAudit(c *fiber.Ctx) error {
+	filter, err := getAuditFilter(c)
+	if err != nil {
+		return err
+	}
+	if c.Query("limit") == "" {
+		filter.Limit = 0
+	}
+	entries, err := db.FindAudit(filter)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="uglynotes-audit.jsonl"`)
+	w := bufio.NewWriter(c)
+	enc := json.NewEncoder(w)
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ahui2016/uglynotes/gitexport"
+	"github.com/ahui2016/uglynotes/importer"
+	"github.com/ahui2016/uglynotes/model"
+)
+
+// commandLineSession 代替会话，表示由命令行完成的操作。
+const commandLineSession = "command-line"
+
+var stdin = bufio.NewScanner(os.Stdin)
+
+func readLine(prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+	stdin.Scan()
+	return strings.TrimRight(stdin.Text(), "\r\n")
+}
+
+// rotateKeyCommand 从标准输入依次读取旧 passphrase 与新 passphrase (各占一行)，
+// 然后用新的密钥重新加密全部笔记。运行前应先停止正在运行的 uglynotes.
+func rotateKeyCommand() {
+	oldPassphrase := readLine("old passphrase: ")
+	newPassphrase := readLine("new passphrase: ")
+	if newPassphrase != readLine("new passphrase again: ") {
+		log.Fatal("the new passphrases do not match")
+	}
+	if err := db.RotateKey(oldPassphrase, newPassphrase); err != nil {
+		log.Fatal(err)
+	}
+	log.Print("OK, all notes are re-encrypted with the new passphrase")
+}
+
+// unlockCommand 启用了加密时，从标准输入读取 passphrase 以便读取笔记。
+func unlockCommand() {
+	if db.DataLocked() {
+		if err := db.UnlockData(readLine("passphrase: ")); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// exportGitCommand 把全部笔记 (包括回收站中的笔记) 的历史版本导出为 git 仓库，
+// 详见 gitexport.Export.
+func exportGitCommand(dir string) {
+	unlockCommand()
+	notes, err := db.AllNotesWithDeleted()
+	if err != nil {
+		log.Fatal(err)
+	}
+	failed, err := gitexport.Export(dir, notes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, reason := range failed {
+		log.Printf("skipped %s", reason)
+	}
+	log.Printf("exported %d notes to %s", len(notes)-len(failed), dir)
+}
+
+// importCommand 导入一个文件夹、zip 文件或单个文件 (格式详见 importers)，
+// 然后打印被跳过的文件与警告。启用了加密时，需要从标准输入读取 passphrase.
+// 运行前应先停止正在运行的 uglynotes.
+func importCommand(format, name string) {
+	unlockCommand()
+	files, err := importer.Open(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	drafts, skipped, err := convertFiles(format, files)
+	if err != nil {
+		log.Fatal(err)
+	}
+	result := importDrafts(drafts, skipped, commandLineSession)
+	for _, issue := range result.Skipped {
+		log.Printf("skipped %s: %s", issue.Path, issue.Reason)
+	}
+	for _, issue := range result.Warnings {
+		log.Printf("warning %s: %s", issue.Path, issue.Reason)
+	}
+
+	summary := importSummary(format, result)
+	entry := model.NewAuditEntry(model.OpBackupImport, result.Imported, "", summary)
+	entry.Session = commandLineSession
+	if err := db.AddAudit(entry); err != nil {
+		log.Print(err)
+	}
+	log.Print(summary)
+}
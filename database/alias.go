@@ -0,0 +1,161 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/ahui2016/uglynotes/stringset"
+	"github.com/asdine/storm/v3"
+)
+
+// TagAlias = model.TagAlias
+type TagAlias = model.TagAlias
+
+// txTagAliases 返回 AliasKey(别名) → 标签。
+func txTagAliases(tx storm.Node) (map[string]string, error) {
+	var all []TagAlias
+	if err := tx.All(&all); err != nil {
+		return nil, err
+	}
+	aliases := make(map[string]string, len(all))
+	for _, alias := range all {
+		aliases[model.AliasKey(alias.Alias)] = alias.Tag
+	}
+	return aliases, nil
+}
+
+// ResolveTags 删除标签中的特殊字符并把别名替换为对应的标签，然后除重、排序。
+func (db *DB) ResolveTags(tags []string) ([]string, error) {
+	return txResolveTags(db.DB, tags)
+}
+
+func txResolveTags(tx storm.Node, tags []string) ([]string, error) {
+	aliases, err := txTagAliases(tx)
+	if err != nil {
+		return nil, err
+	}
+	var resolved []string
+	for _, tag := range tags {
+		if tag = model.PurifyTag(tag); tag != "" {
+			resolved = append(resolved, model.ResolveTag(tag, aliases))
+		}
+	}
+	return stringset.UniqueSort(resolved), nil
+}
+
+// ResolveTag 把别名替换为对应的标签，用于搜索。
+func (db *DB) ResolveTag(name string) (string, error) {
+	aliases, err := txTagAliases(db.DB)
+	if err != nil {
+		return "", err
+	}
+	return model.ResolveTag(model.PurifyTag(name), aliases), nil
+}
+
+// AllTagAliases fetches all aliases, sorted by alias.
+func (db *DB) AllTagAliases() (aliases []TagAlias, err error) {
+	err = db.DB.All(&aliases)
+	return
+}
+
+// AddTagAlias 把 alias 设为 tag 的别名，别名不区分大小写。
+// 已被笔记使用的标签 (不区分大小写) 不能用作别名（请先把它合并到 tag）。
+// 如果 tag 本身是别名，则使用它对应的标签；原本指向 alias 的别名改为指向 tag,
+// 因此不会出现别名的别名。
+func (db *DB) AddTagAlias(alias, tag string) (*TagAlias, error) {
+	alias, tag = model.PurifyTag(alias), model.PurifyTag(tag)
+	if alias == "" || tag == "" {
+		return nil, errors.New("alias or tag is empty")
+	}
+
+	tx := db.mustBegin()
+	defer tx.Rollback()
+
+	aliases, err := txTagAliases(tx)
+	if err != nil {
+		return nil, err
+	}
+	key := model.AliasKey(alias)
+	if _, ok := aliases[key]; ok {
+		return nil, errors.New("别名 [" + alias + "] 已存在")
+	}
+	tag = model.ResolveTag(tag, aliases)
+	if model.IsTagUnder(model.AliasKey(tag), key) {
+		return nil, errors.New("不能把 [" + alias + "] 设为 [" + tag + "] 的别名")
+	}
+	used, err := txTagUsedFold(tx, key)
+	if err != nil {
+		return nil, err
+	}
+	if used {
+		return nil, errors.New("标签 [" + alias + "] 已存在，不能用作别名")
+	}
+	if err := txRenameAliasTags(tx, alias, tag); err != nil {
+		return nil, err
+	}
+	tagAlias := model.NewTagAlias(alias, tag)
+	if err := tx.Save(tagAlias); err != nil {
+		return nil, err
+	}
+	return tagAlias, tx.Commit()
+}
+
+// txTagUsedFold 判断是否有标签 (不区分大小写) 是 key 或它的子标签。
+func txTagUsedFold(tx storm.Node, key string) (bool, error) {
+	var tags []Tag
+	err := tx.All(&tags)
+	if err != nil {
+		return false, err
+	}
+	for _, tag := range tags {
+		if model.IsTagUnder(model.AliasKey(tag.Name), key) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DeleteTagAlias 删除别名 (不区分大小写)。
+func (db *DB) DeleteTagAlias(name string) (alias TagAlias, err error) {
+	if err = db.DB.One("Alias", model.AliasKey(name), &alias); err != nil {
+		return
+	}
+	err = db.DB.DeleteStruct(&alias)
+	return
+}
+
+// txRenameAliasTags 把指向 oldName (或其子标签) 的别名改为指向 newName (或其子标签)。
+func txRenameAliasTags(tx storm.Node, oldName, newName string) error {
+	var all []TagAlias
+	if err := tx.All(&all); err != nil {
+		return err
+	}
+	for i := range all {
+		if !model.IsTagUnder(all[i].Tag, oldName) {
+			continue
+		}
+		tag := model.MoveTagName(all[i].Tag, oldName, newName)
+		if err := tx.UpdateField(&all[i], "Tag", tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// txMergeAliasTags 把指向 source 的别名改为指向 target (不包括子标签)。
+func txMergeAliasTags(tx storm.Node, source, target string) error {
+	var aliases []TagAlias
+	err := tx.Find("Tag", source, &aliases)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := range aliases {
+		if err := tx.UpdateField(&aliases[i], "Tag", target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
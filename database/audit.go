@@ -0,0 +1,61 @@
+package database
+
+import (
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/ahui2016/uglynotes/util"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+)
+
+// AuditEntry = model.AuditEntry
+type AuditEntry = model.AuditEntry
+
+// AuditFilter 用于查询审计日志，空值表示不限。
+type AuditFilter struct {
+	Operation string
+	Target    string
+	Since     string // ISO8601, 包含
+	Until     string // ISO8601, 包含 (可只写日期，如 "2021-03-01")
+	Limit     int
+}
+
+// AddAudit 添加一条审计日志。审计日志只增不改，因此不提供修改和删除的方法。
+func (db *DB) AddAudit(entry *AuditEntry) error {
+	return db.DB.Save(entry)
+}
+
+// FindAudit 按条件查询审计日志，按时间倒序排列（最新的在前）。
+func (db *DB) FindAudit(filter AuditFilter) (entries []AuditEntry, err error) {
+	var matchers []q.Matcher
+	if filter.Operation != "" {
+		matchers = append(matchers, q.Eq("Operation", filter.Operation))
+	}
+	if filter.Target != "" {
+		matchers = append(matchers,
+			q.NewFieldMatcher("Targets", targetMatcher(filter.Target)))
+	}
+	if filter.Since != "" {
+		matchers = append(matchers, q.Gte("Time", filter.Since))
+	}
+	if filter.Until != "" {
+		// 加上 "~" (比 ISO8601 中出现的全部字符都大) 使得只写日期时包含当天全部日志
+		matchers = append(matchers, q.Lte("Time", filter.Until+"~"))
+	}
+	query := db.DB.Select(matchers...).OrderBy("Time").Reverse()
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	err = query.Find(&entries)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+type targetMatcher string
+
+// MatchField implements q.FieldMatcher
+func (target targetMatcher) MatchField(v interface{}) (bool, error) {
+	targets, _ := v.([]string)
+	return util.HasString(targets, string(target)), nil
+}
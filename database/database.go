@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/ahui2016/uglynotes/encrypt"
 	"github.com/ahui2016/uglynotes/model"
 	"github.com/ahui2016/uglynotes/settings"
 	"github.com/ahui2016/uglynotes/stmt"
@@ -32,6 +34,7 @@ type (
 	Tag        = model.Tag
 	TagGroup   = model.TagGroup
 	IncreaseID = model.IncreaseID
+	Milestone  = model.Milestone
 	Set        = stringset.Set
 )
 
@@ -49,6 +52,9 @@ func (db *DB2) Open(dbPath string) (err error) {
 	if _, err = db.DB.Exec(stmt.CreateTables); err != nil {
 		return err
 	}
+	if err = migratePatchTable(db.DB); err != nil {
+		return err
+	}
 	db.path = dbPath
 	// db.Sess = session.New(session.Config{
 	// 	Expiration: mustParseDuration(config.MaxAge),
@@ -58,6 +64,37 @@ func (db *DB2) Open(dbPath string) (err error) {
 	err2 := initTotalSize(db.DB)
 	return util.WrapErrors(err1, err2)
 }
+
+// migratePatchTable 为旧的 patch 表添加 created_at, size, session 列。
+func migratePatchTable(db *sql.DB) error {
+	rows, err := db.Query(stmt.PatchColumns)
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		columns[name] = true
+	}
+	if err := util.WrapErrors(rows.Err(), rows.Close()); err != nil {
+		return err
+	}
+	for _, add := range stmt.AddPatchColumns {
+		if columns[add[0]] {
+			continue
+		}
+		if _, err := db.Exec(add[1]); err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(stmt.CreatePatchIndex)
+	return err
+}
+
 func (db *DB2) Close() error {
 	return db.DB.Close()
 }
@@ -113,7 +150,7 @@ func (db *DB2) ImportNotes(notes []Note) (err error) {
 			return fmt.Errorf("importTags: %v", err)
 		}
 		if err = importPatches(stmtInsertPatch, stmtInsertNotePatch,
-			note.ID, note.Patches); err != nil {
+			&note); err != nil {
 			return fmt.Errorf("importPatches: %v", err)
 		}
 		if err = increaseTotalSize(tx, note.Size); err != nil {
@@ -186,19 +223,19 @@ func importTag(
 	return err
 }
 
-func importPatches(stmt1, stmt2 *sql.Stmt, noteID string, patches []string) (
-	err error) {
-	for _, diff := range patches {
-		if err = importPatch(stmt1, stmt2, noteID, diff); err != nil {
+func importPatches(stmt1, stmt2 *sql.Stmt, note *Note) (err error) {
+	versions := note.History()
+	for i, diff := range note.Patches {
+		if err = importPatch(stmt1, stmt2, note.ID, diff, versions[i]); err != nil {
 			return
 		}
 	}
 	return
 }
 
-func importPatch(stmt1, stmt2 *sql.Stmt, noteID, diff string) error {
+func importPatch(stmt1, stmt2 *sql.Stmt, noteID, diff string, version model.Version) error {
 	patchID := model.NextTimeID()
-	_, err1 := stmt1.Exec(patchID, diff)
+	_, err1 := stmt1.Exec(patchID, diff, version.CreatedAt, version.Size, version.Session)
 	_, err2 := stmt2.Exec(noteID, patchID)
 	return util.WrapErrors(err1, err2)
 }
@@ -284,6 +321,12 @@ type DB struct {
 	DB   *storm.DB
 	Sess *session.Store
 
+	// key 用于加密笔记的标题与内容，只保存在内存中，为 nil 表示未启用加密或尚未解锁。
+	key *encrypt.Key
+
+	// titles 笔记标题的索引，用于按标题查找链接的目标 (详见 titleIndex)。
+	titles titleIndex
+
 	// 只在 package database 外部使用锁，不在 package database 内部使用锁。
 	sync.Mutex
 }
@@ -295,8 +338,10 @@ func (db *DB) Open(dbPath string) (err error) {
 	}
 	db.path = dbPath
 	db.Sess = session.New(session.Config{
-		Expiration: mustParseDuration(config.MaxAge),
-		CookieName: cookieName,
+		Expiration:     mustParseDuration(config.MaxAge),
+		CookieName:     cookieName,
+		CookieHTTPOnly: true,
+		CookieSameSite: "Lax",
 	})
 	err1 := db.createIndexes()
 	err2 := db.initFirstID()
@@ -326,8 +371,13 @@ func (db *DB) createIndexes() error {
 	err1 := db.DB.Init(&Note{})
 	err2 := db.DB.Init(&Tag{})
 	err3 := db.DB.Init(&TagGroup{})
-	err4 := db.reIndex()
-	return util.WrapErrors(err1, err2, err3, err4)
+	err4 := db.DB.Init(&AuditEntry{})
+	err5 := db.DB.Init(&Share{})
+	err6 := db.DB.Init(&NoteChange{})
+	err7 := db.DB.Init(&NoteLink{})
+	err8 := db.DB.Init(&TagAlias{})
+	err9 := db.reIndex()
+	return util.WrapErrors(err1, err2, err3, err4, err5, err6, err7, err8, err9)
 }
 
 func (db *DB) reIndex() error {
@@ -339,6 +389,7 @@ func (db *DB) reIndex() error {
 }
 
 // Upgrade 将旧的历史版本系统（全文保存）升级至新的历史版本系统（只保存差异）。
+// 启用加密时必须先输入 passphrase (详见 UnlockData)。
 func (db *DB) Upgrade() error {
 	if db.noNeedToUpgrade() {
 		return nil
@@ -347,8 +398,8 @@ func (db *DB) Upgrade() error {
 	tx := db.mustBegin()
 	defer tx.Rollback()
 
-	var all []Note
-	if err := tx.All(&all); err != nil {
+	all, err := db.txDecryptedNotes(tx)
+	if err != nil {
 		return err
 	}
 	for _, note := range all {
@@ -366,16 +417,26 @@ func (db *DB) Upgrade() error {
 		for i := 1; i < len(histories); i++ {
 			a := histories[i-1].Contents
 			b := histories[i].Contents
-			patch, err := getUnifiedDiffString(a, b)
+			patch, err := model.UnifiedDiff(difflib.SplitLines(a), difflib.SplitLines(b))
 			if err != nil {
 				return err
 			}
 			if patch != "" {
+				createdAt := histories[i].CreatedAt
+				if createdAt == "" {
+					createdAt = note.UpdatedAt // 最后一个版本是 note.Contents
+				}
 				note.Patches = append(note.Patches, patch)
+				note.Versions = append(note.Versions,
+					model.Version{CreatedAt: createdAt, Size: len(patch)})
 			}
 		}
 		note.Contents = "" // 清空 Contents, 历史版本系统升级后废除 Contents
-		err1 := tx.Save(&note)
+		encrypted, err := db.encrypted(&note)
+		if err != nil {
+			return err
+		}
+		err1 := tx.Save(encrypted)
 		err2 := txIncreaseTotalSize(tx, note.Size) // 估算 size，不准确但问题不大
 		if err := util.WrapErrors(err1, err2); err != nil {
 			return err
@@ -384,7 +445,11 @@ func (db *DB) Upgrade() error {
 	if err := tx.Drop("History"); err != nil {
 		return err
 	}
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.titles.reset()
+	return nil
 }
 
 func txNoteHistories(tx storm.Node, noteID string) (histories []History, err error) {
@@ -396,16 +461,6 @@ func txNoteHistories(tx storm.Node, noteID string) (histories []History, err err
 	return
 }
 
-func getUnifiedDiffString(a, b string) (string, error) {
-	diff := difflib.LineDiffParams{
-		A:        difflib.SplitLines(a),
-		B:        difflib.SplitLines(b),
-		FromFile: " ",
-		ToFile:   " ",
-	}
-	return difflib.GetUnifiedDiffString(diff)
-}
-
 func (db *DB) noNeedToUpgrade() bool {
 	var histories []History
 	err := db.DB.All(&histories)
@@ -427,6 +482,22 @@ func (db *DB) NewNote(noteType model.NoteType) *Note {
 
 // Insert .
 func (db *DB) Insert(note *Note) error {
+	return db.insert(note, nil)
+}
+
+// InsertFork 插入从 fork.ForkOf 复制而来的新笔记，同时在原笔记的 Forks 中记录。
+func (db *DB) InsertFork(fork *Note) error {
+	return db.insert(fork, func(tx storm.Node) error {
+		var source Note
+		if err := tx.One("ID", fork.ForkOf, &source); err != nil {
+			return fmt.Errorf("id[%s] %w", fork.ForkOf, err)
+		}
+		return tx.UpdateField(&source, "Forks", append(source.Forks, fork.ID))
+	})
+}
+
+// insert 插入新笔记，如果 fn 不是 nil, 则在同一个事务中执行 fn.
+func (db *DB) insert(note *Note, fn func(tx storm.Node) error) error {
 	if err := db.checkTotalSize(note.Size); err != nil {
 		return err
 	}
@@ -434,18 +505,37 @@ func (db *DB) Insert(note *Note) error {
 		return err
 	}
 
+	encrypted, err := db.encrypted(note)
+	if err != nil {
+		return err
+	}
+
 	tx := db.mustBegin()
 	defer tx.Rollback()
+	defer db.titles.discard()
 
-	err1 := tx.Save(note)
+	err1 := tx.Save(encrypted)
 	err2 := saveTagGroup(tx, model.NewTagGroup(note.Tags))
 	err3 := addTags(tx, note.Tags, note.ID)
-	if err := util.WrapErrors(err1, err2, err3); err != nil {
+	err4 := txLogNoteChange(tx, note, note.CreatedAt)
+	if err := util.WrapErrors(err1, err2, err3, err4); err != nil {
 		return err
 	}
+	db.titles.stage(note.ID, note.Title)
+	err1 = db.txUpdateLinks(tx, note)
+	err2 = db.txResolveBrokenLinks(tx, note)
+	if err := util.WrapErrors(err1, err2); err != nil {
+		return err
+	}
+	if fn != nil {
+		if err := fn(tx); err != nil {
+			return err
+		}
+	}
 	if err := tx.Commit(); err != nil {
 		return err
 	}
+	db.titles.commit()
 	return db.increaseTotalSize(note.Size)
 }
 
@@ -499,8 +589,11 @@ func (db *DB) checkExist(id string) error {
 
 // GetByID .
 func (db *DB) GetByID(id string) (note Note, err error) {
-	err = db.DB.One("ID", id, &note)
-	return note, err
+	if err = db.DB.One("ID", id, &note); err != nil {
+		return
+	}
+	err = db.decryptNote(&note)
+	return
 }
 
 func addTags(tx storm.Node, tags []string, noteID string) error {
@@ -628,6 +721,10 @@ func scanNote(rows *sql.Rows) (note Note, err error) {
 func (db *DB) AllNotes() (notes []Note, err error) {
 	err = db.DB.Select(q.Eq("Deleted", false)).
 		OrderBy("UpdatedAt").Find(&notes)
+	if err != nil {
+		return
+	}
+	err = db.decryptNotes(notes)
 	return
 }
 
@@ -635,11 +732,24 @@ func (db *DB) AllNotes() (notes []Note, err error) {
 func (db *DB) AllDeletedNotes() (notes []Note, err error) {
 	err = db.DB.Select(q.Eq("Deleted", true)).
 		OrderBy("UpdatedAt").Find(&notes)
+	if err != nil {
+		return
+	}
+	err = db.decryptNotes(notes)
 	return
 }
 
 // AllNotesWithDeleted .
 func (db *DB) AllNotesWithDeleted() (notes []Note, err error) {
+	if notes, err = db.RawNotesWithDeleted(); err != nil {
+		return
+	}
+	err = db.decryptNotes(notes)
+	return
+}
+
+// RawNotesWithDeleted 与 AllNotesWithDeleted 相同，但不解密（用于导出加密备份）。
+func (db *DB) RawNotesWithDeleted() (notes []Note, err error) {
 	err = db.DB.AllByIndex("UpdatedAt", &notes)
 	return
 }
@@ -672,19 +782,32 @@ func txAllTagGroups(tx storm.Node) (groups []TagGroup, err error) {
 }
 
 // ChangeType 同时也可能需要修改标题。
+// 客户端加密的笔记与普通笔记的内容格式不同，不能互相转换。
 func (db *DB) ChangeType(id string, noteType NoteType) error {
 	note, err := db.GetByID(id)
 	if err != nil {
 		return err
 	}
+	if note.IsEncrypted() != (noteType == model.Encrypted) {
+		return errors.New("不能在加密笔记与普通笔记之间转换")
+	}
 	note.Type = noteType
 	if noteType == model.Markdown {
 		note.SetTitle(note.Title)
 	}
-	return db.DB.Update(&note)
+	encrypted, err := db.encrypted(&note)
+	if err != nil {
+		return err
+	}
+	if err := db.DB.Update(encrypted); err != nil {
+		return err
+	}
+	db.titles.stage(note.ID, note.Title)
+	db.titles.commit()
+	return nil
 }
 
-// UpdateTags .
+// UpdateTags 会把别名替换为对应的标签 (详见 ResolveTags)。
 func (db *DB) UpdateTags(id string, tags []string) error {
 	note, err := db.GetByID(id)
 	if err != nil {
@@ -693,6 +816,9 @@ func (db *DB) UpdateTags(id string, tags []string) error {
 	tx := db.mustBegin()
 	defer tx.Rollback()
 
+	if tags, err = txResolveTags(tx, tags); err != nil {
+		return err
+	}
 	toAdd, toDelete := util.SliceDifference(tags, note.Tags)
 
 	e1 := deleteTags(tx, toDelete, note.ID)
@@ -700,8 +826,9 @@ func (db *DB) UpdateTags(id string, tags []string) error {
 	e3 := note.SetTags(tags)
 	e4 := tx.UpdateField(&note, "Tags", note.Tags)
 	e5 := saveTagGroup(tx, model.NewTagGroup(tags))
+	e6 := txLogNoteChange(tx, &note, model.TimeNow())
 
-	if err := util.WrapErrors(e1, e2, e3, e4, e5); err != nil {
+	if err := util.WrapErrors(e1, e2, e3, e4, e5, e6); err != nil {
 		return err
 	}
 	return tx.Commit()
@@ -730,27 +857,79 @@ func (db *DB) GetTag(name string) (tag Tag, err error) {
 	return
 }
 
-// AddPatchSetTitle .
-func (db *DB) AddPatchSetTitle(id, patch, contents string) (int, error) {
+// AddPatchSetTitle 添加 patch 并更新标题，session 是创建该版本的会话 (详见 SessionLabel)。
+// 标题改变时，renamed 是因 [[旧标题]] 链接而被修改或跳过的笔记 (详见 txRenameLinks)。
+func (db *DB) AddPatchSetTitle(id, patch, contents, session string) (count int, renamed RenamedLinks, err error) {
 	note, err := db.GetByID(id)
 	if err != nil {
-		return 0, err
+		return 0, renamed, err
 	}
+	oldTitle := note.Title
 	if err := note.AddPatchNow(patch, contents); err != nil {
-		return 0, err
+		return 0, renamed, err
+	}
+	note.SetVersionSession(session)
+	encrypted, err := db.encrypted(&note)
+	if err != nil {
+		return 0, renamed, err
 	}
 
 	tx := db.mustBegin()
 	defer tx.Rollback()
+	defer db.titles.discard()
 
-	err1 := tx.Update(&note)
+	err1 := tx.Update(encrypted)
 	err2 := txCheckIncreaseTotalSize(tx, len(patch))
+	err3 := db.txUpdateLinks(tx, &note)
+	if err := util.WrapErrors(err1, err2, err3); err != nil {
+		return 0, renamed, err
+	}
+	if note.Title != oldTitle {
+		db.titles.stage(note.ID, note.Title)
+		var err1 error
+		renamed, err1 = db.txRenameLinks(tx, &note, oldTitle, session)
+		err2 := db.txResolveBrokenLinks(tx, &note)
+		if err := util.WrapErrors(err1, err2); err != nil {
+			return 0, renamed, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, renamed, err
+	}
+	db.titles.commit()
+	return len(note.Patches), renamed, nil
+}
 
-	if err := util.WrapErrors(err1, err2); err != nil {
-		return 0, err
+// SetMilestone 添加 (或替换) 笔记的里程碑。
+func (db *DB) SetMilestone(id string, milestone Milestone) error {
+	note, err := db.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := note.SetMilestone(milestone); err != nil {
+		return err
 	}
-	err = tx.Commit()
-	return len(note.Patches), err
+	return db.updateMilestones(&note)
+}
+
+// DeleteMilestone 删除笔记中指定版本的里程碑。
+func (db *DB) DeleteMilestone(id string, version int) error {
+	note, err := db.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if !note.DeleteMilestone(version) {
+		return errors.New("milestone not found")
+	}
+	return db.updateMilestones(&note)
+}
+
+func (db *DB) updateMilestones(note *Note) error {
+	encrypted, err := db.encrypted(note)
+	if err != nil {
+		return err
+	}
+	return db.DB.UpdateField(encrypted, "Milestones", encrypted.Milestones)
 }
 
 func txUnprotectedHistories(tx storm.Node, noteID string) (histories []History, err error) {
@@ -768,15 +947,18 @@ func (db *DB) SetTagGroupProtected(groupID string, protected bool) error {
 		&TagGroup{ID: groupID}, "Protected", protected)
 }
 
-// GetByTag returns notes without contents.
+// GetByTag returns notes without contents, including notes of the child tags.
 func (db *DB) GetByTag(name string) (notes []Note, err error) {
-	tag, err := db.GetTag(name)
+	if name, err = db.ResolveTag(name); err != nil {
+		return
+	}
+	noteIDs, err := db.noteIDsUnder(name)
 	if err != nil {
 		return nil, fmt.Errorf("tag[%s] %w", name, err)
 	}
-	for i := range tag.NoteIDs {
+	for i := range noteIDs {
 		var note Note
-		note, err = db.GetByID(tag.NoteIDs[i])
+		note, err = db.GetByID(noteIDs[i])
 		if err != nil {
 			return
 		}
@@ -786,25 +968,45 @@ func (db *DB) GetByTag(name string) (notes []Note, err error) {
 	return
 }
 
-// RenameTag .
+// RenameTag 同时重命名全部子标签，因此也可以用来移动整个子树，
+// 例如把 "vim" 改为 "editor/vim" 时，"vim/plugin" 也会变为 "editor/vim/plugin".
+// oldName 可以是不存在的父标签（只作为子标签的前缀）。
 func (db *DB) RenameTag(oldName, newName string) error {
-	_, err := db.GetTag(newName)
-	if err != nil && err != storm.ErrNotFound {
-		return fmt.Errorf("tag[%s] %w", newName, err)
-	}
-	if err == nil {
-		return errors.New("标签名称 [" + newName + "] 已存在")
+	if model.IsTagUnder(newName, oldName) {
+		return errors.New("不能把标签 [" + oldName + "] 移动到它自己之下")
 	}
-
-	tag, err := db.GetTag(oldName)
+	tags, err := txTagsUnder(db.DB, oldName)
 	if err != nil {
 		return fmt.Errorf("tag[%s] %w", oldName, err)
 	}
+	aliases, err := txTagAliases(db.DB)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		name := model.MoveTagName(tag.Name, oldName, newName)
+		if model.ResolveTag(name, aliases) != name {
+			return errors.New("标签名称 [" + name + "] 是别名")
+		}
+		_, err := db.GetTag(name)
+		if err != nil && err != storm.ErrNotFound {
+			return fmt.Errorf("tag[%s] %w", name, err)
+		}
+		if err == nil {
+			return errors.New("标签名称 [" + name + "] 已存在")
+		}
+	}
 
 	tx := db.mustBegin()
 	defer tx.Rollback()
 
-	if err := renameTag(tx, tag, newName); err != nil {
+	for _, tag := range tags {
+		name := model.MoveTagName(tag.Name, oldName, newName)
+		if err := renameTag(tx, tag, name); err != nil {
+			return err
+		}
+	}
+	if err := txRenameAliasTags(tx, oldName, newName); err != nil {
 		return err
 	}
 	return tx.Commit()
@@ -830,6 +1032,9 @@ func notesRenameTag(tx storm.Node, tag Tag, newName string) error {
 		if err := tx.UpdateField(&note, "Tags", note.Tags); err != nil {
 			return err
 		}
+		if err := txLogNoteChange(tx, &note, model.TimeNow()); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -848,17 +1053,138 @@ func tagGroupsRenameTag(tx storm.Node, oldName, newName string) error {
 	return nil
 }
 
-// SearchTagGroup 通过标签组搜索笔记。
+// MergeTags 把标签 source 合并到 target (target 是别名时使用对应的标签，不存在时新建)：
+// 使用 source 的笔记 (包括回收站中的笔记) 改为使用 target, 标签组中的 source 也改为 target,
+// 指向 source 的别名改为指向 target, 最后删除 source. 子标签不受影响。
+func (db *DB) MergeTags(source, target string) (merged Tag, err error) {
+	tx := db.mustBegin()
+	defer tx.Rollback()
+
+	var src Tag
+	if err = tx.One("Name", source, &src); err != nil {
+		return merged, fmt.Errorf("tag[%s] %w", source, err)
+	}
+	aliases, err := txTagAliases(tx)
+	if err != nil {
+		return
+	}
+	if target = model.ResolveTag(model.PurifyTag(target), aliases); target == "" {
+		return merged, errors.New("target tag is empty")
+	}
+	if target == source {
+		return merged, errors.New("不能把标签 [" + source + "] 合并到它自己")
+	}
+	err = tx.One("Name", target, &merged)
+	if err != nil && err != storm.ErrNotFound {
+		return
+	}
+	if err == storm.ErrNotFound {
+		merged = Tag{Name: target, CreatedAt: src.CreatedAt}
+	}
+	if src.CreatedAt < merged.CreatedAt {
+		merged.CreatedAt = src.CreatedAt
+	}
+	for _, noteID := range src.NoteIDs {
+		merged.Add(noteID)
+	}
+
+	err1 := notesRenameTag(tx, src, target)
+	err2 := deletedNotesRenameTag(tx, source, target)
+	err3 := tagGroupsMergeTag(tx, source, target)
+	err4 := txMergeAliasTags(tx, source, target)
+	err5 := tx.DeleteStruct(&src)
+	err6 := tx.Save(&merged)
+	if err = util.WrapErrors(err1, err2, err3, err4, err5, err6); err != nil {
+		return
+	}
+	return merged, tx.Commit()
+}
+
+// deletedNotesRenameTag 修改回收站中的笔记的标签 (回收站中的笔记不计入 Tag.NoteIDs),
+// 以免恢复笔记时旧标签重新出现。
+func deletedNotesRenameTag(tx storm.Node, oldName, newName string) error {
+	var notes []Note
+	err := tx.Select(q.Eq("Deleted", true)).Find(&notes)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := range notes {
+		note := &notes[i]
+		if !util.HasString(note.Tags, oldName) {
+			continue
+		}
+		note.RenameTag(oldName, newName)
+		if err := tx.UpdateField(note, "Tags", note.Tags); err != nil {
+			return err
+		}
+		if err := txLogNoteChange(tx, note, model.TimeNow()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tagGroupsMergeTag 把标签组中的 source 改为 target (除重)。
+// 因为 TagGroup.Tags 是 unique index, 改名后与其他标签组相同时，只保留其他标签组
+// (合并 Protected)；改名后少于两个标签的标签组则删除。
+func tagGroupsMergeTag(tx storm.Node, source, target string) error {
+	groups, err := txAllTagGroups(tx)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for i := range groups {
+		group := &groups[i]
+		if !util.HasString(group.Tags, source) {
+			continue
+		}
+		group.RenameTag(source, target)
+		if len(group.Tags) < 2 {
+			if err := tx.DeleteStruct(group); err != nil {
+				return err
+			}
+			continue
+		}
+		var other TagGroup
+		err := tx.One("Tags", group.Tags, &other)
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+		if err == storm.ErrNotFound || other.ID == group.ID {
+			if err := tx.UpdateField(group, "Tags", group.Tags); err != nil {
+				return err
+			}
+			continue
+		}
+		if group.Protected && !other.Protected {
+			if err := tx.UpdateField(&other, "Protected", true); err != nil {
+				return err
+			}
+		}
+		if err := tx.DeleteStruct(group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchTagGroup 通过标签组搜索笔记，父标签同时匹配其全部子标签，别名则替换为对应的标签。
 // 如果其中一个标签不存在，会返回错误，另外一种处理方式是忽略找不到的标签。
 // 但我选择了返回错误，因为本项目的设计思想之一是 informational(更多信息)。
 func (db *DB) SearchTagGroup(tags []string) ([]Note, error) {
+	tags, err := db.ResolveTags(tags)
+	if err != nil {
+		return nil, err
+	}
 	var idGroups []*Set
 	for i := range tags {
-		var tag Tag
-		if err := db.DB.One("Name", tags[i], &tag); err != nil {
+		noteIDs, err := db.noteIDsUnder(tags[i])
+		if err != nil {
 			return nil, fmt.Errorf("Tag[%s] %w", tags[i], err)
 		}
-		idGroups = append(idGroups, stringset.NewSet(tag.NoteIDs))
+		idGroups = append(idGroups, stringset.NewSet(noteIDs))
 	}
 	noteIDs := stringset.Intersect(idGroups).Slice()
 	return db.getByIDs(noteIDs)
@@ -869,15 +1195,23 @@ func (db *DB) getByIDs(noteIDs []string) ([]Note, error) {
 	err := db.DB.Select(q.In("ID", noteIDs)).
 		OrderBy("UpdatedAt").Find(&notes)
 	if err == storm.ErrNotFound {
-		err = nil
+		return nil, nil
 	}
-	return notes, err
+	if err != nil {
+		return nil, err
+	}
+	return notes, db.decryptNotes(notes)
 }
 
 // SearchTitle by regular expression.
+// 启用加密后，标题无法在数据库中直接搜索，只能全部解密后逐一匹配。
+// 客户端加密的笔记不参与搜索。
 func (db *DB) SearchTitle(pattern string) ([]Note, error) {
+	if db.Encrypted() {
+		return db.searchEncryptedTitle(pattern)
+	}
 	var notes []Note
-	err := db.DB.Select(q.Re("Title", pattern)).
+	err := db.DB.Select(q.Re("Title", pattern), q.Not(q.Eq("Type", model.Encrypted))).
 		OrderBy("UpdatedAt").Find(&notes)
 	if err == storm.ErrNotFound {
 		err = nil
@@ -885,6 +1219,23 @@ func (db *DB) SearchTitle(pattern string) ([]Note, error) {
 	return notes, err
 }
 
+func (db *DB) searchEncryptedTitle(pattern string) (notes []Note, err error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	all, err := db.AllNotesWithDeleted()
+	if err != nil {
+		return nil, err
+	}
+	for _, note := range all {
+		if !note.IsEncrypted() && re.MatchString(note.Title) {
+			notes = append(notes, note)
+		}
+	}
+	return
+}
+
 // SetNoteDeleted .
 func (db *DB) SetNoteDeleted(id string, deleted bool) error {
 	note, err := db.GetByID(id)
@@ -902,7 +1253,10 @@ func (db *DB) SetNoteDeleted(id string, deleted bool) error {
 		err1 = addTags(tx, note.Tags, note.ID)
 	}
 	err2 := tx.UpdateField(&note, "Deleted", deleted)
-	if err := util.WrapErrors(err1, err2); err != nil {
+	note.Deleted = deleted
+	err3 := txLogNoteChange(tx, &note, model.TimeNow())
+	err4 := txSetLinksBroken(tx, id, deleted)
+	if err := util.WrapErrors(err1, err2, err3, err4); err != nil {
 		return err
 	}
 	return tx.Commit()
@@ -922,9 +1276,32 @@ func (db *DB) DeleteNoteForever(id string) error {
 func txDeleteOneNote(tx storm.Node, id string) error {
 	var note Note
 	err1 := tx.One("ID", id, &note)
+	if err1 == nil && note.ForkOf != "" {
+		err1 = txRemoveFork(tx, note.ForkOf, id)
+	}
 	err2 := tx.DeleteStruct(&note)
 	err3 := txIncreaseTotalSize(tx, -note.Size)
-	return util.WrapErrors(err1, err2, err3)
+	err4 := txDeleteNoteShares(tx, id)
+	err5 := txDeleteNoteChanges(tx, id)
+	err6 := txUnlinkNote(tx, id)
+	return util.WrapErrors(err1, err2, err3, err4, err5, err6)
+}
+
+// txRemoveFork 从原笔记的 Forks 中删除 forkID (原笔记已被删除时忽略)。
+func txRemoveFork(tx storm.Node, sourceID, forkID string) error {
+	var source Note
+	err := tx.One("ID", sourceID, &source)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	i := util.StringIndex(source.Forks, forkID)
+	if i < 0 {
+		return nil
+	}
+	return tx.UpdateField(&source, "Forks", util.DeleteFromSlice(source.Forks, i))
 }
 
 // DeleteTag .
@@ -955,35 +1332,104 @@ func notesDeleteTag(tx storm.Node, tag Tag) error {
 		if err := tx.UpdateField(&note, "Tags", note.Tags); err != nil {
 			return err
 		}
+		if err := txLogNoteChange(tx, &note, model.TimeNow()); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// DeleteNoteHistory .
-func (db *DB) DeleteNoteHistory(noteID string) error {
-	tx := db.mustBegin()
-	defer tx.Rollback()
+// CompactHistory 压缩笔记的历史版本，把第 before 个版本之前的版本合并为一个基础 patch,
+// 受保护的里程碑、分享链接固定的版本以及最新版本会被保留（详见 Note.Compact）。
+// 同时更新分享链接的版本号与数据库总体积，返回被删除的版本数量。
+func (db *DB) CompactHistory(id string, before int) (removed int, err error) {
+	note, err := db.GetByID(id)
+	if err != nil {
+		return 0, err
+	}
+	return db.compactNote(&note, before, nil)
+}
 
-	query := tx.Select(q.Eq("NoteID", noteID), q.Eq("Protected", false))
-	if err := txDeleteHistories(tx, query); err != nil {
-		return err
+// ApplyRetention 按保留策略合并全部笔记的历史版本（详见 Note.RetainedVersions），
+// 返回被合并的笔记的 id 以及被删除的版本总数。
+// 客户端加密的笔记无法在服务器端还原内容，因此跳过。
+func (db *DB) ApplyRetention(now time.Time, allDays, dailyDays int) (
+	ids []string, removed int, err error) {
+
+	var notes []Note
+	if err := db.DB.All(&notes); err != nil {
+		return nil, 0, err
 	}
-	return tx.Commit()
+	if err := db.decryptNotes(notes); err != nil {
+		return nil, 0, err
+	}
+	for i := range notes {
+		note := &notes[i]
+		if note.IsEncrypted() {
+			continue
+		}
+		keep := note.RetainedVersions(now, allDays, dailyDays)
+		n, err := db.compactNote(note, len(note.Patches), keep)
+		if err != nil {
+			return ids, removed, fmt.Errorf("id[%s] %w", note.ID, err)
+		}
+		if n > 0 {
+			ids = append(ids, note.ID)
+			removed += n
+		}
+	}
+	return ids, removed, nil
 }
 
-func txDeleteHistories(tx storm.Node, query storm.Query) error {
-	var (
-		size      int
-		histories []History
-	)
-	err1 := query.Find(&histories)
-	if err1 == storm.ErrNotFound {
-		return nil
+// compactNote 除了 keep 之外，还会保留受保护的里程碑与分享链接固定的版本。
+func (db *DB) compactNote(note *Note, before int, keep []int) (removed int, err error) {
+	shares, err := db.NoteShares(note.ID)
+	if err != nil {
+		return 0, err
 	}
-	for i := range histories {
-		size += histories[i].Size
+	keep = append(keep, note.ProtectedVersions()...)
+	for _, share := range shares {
+		if share.Version > 0 {
+			keep = append(keep, share.Version)
+		}
 	}
-	err2 := txIncreaseTotalSize(tx, -size)
-	err3 := query.Delete(&History{})
-	return util.WrapErrors(err1, err2, err3)
+	oldCount, oldSize := len(note.Patches), note.Size
+	renumber, err := note.Compact(before, keep)
+	if err != nil {
+		return 0, err
+	}
+	if removed = oldCount - len(note.Patches); removed == 0 {
+		return 0, nil
+	}
+
+	encrypted, err := db.encrypted(note)
+	if err != nil {
+		return 0, err
+	}
+
+	tx := db.mustBegin()
+	defer tx.Rollback()
+
+	if err := tx.Save(encrypted); err != nil {
+		return 0, err
+	}
+	for i := range shares {
+		if shares[i].Version == 0 {
+			continue
+		}
+		shares[i].Version = renumber[shares[i].Version]
+		if err := tx.Update(&shares[i]); err != nil {
+			return 0, err
+		}
+	}
+	if err := txIncreaseTotalSize(tx, note.Size-oldSize); err != nil {
+		return 0, err
+	}
+	return removed, tx.Commit()
+}
+
+// GetTagGroup .
+func (db *DB) GetTagGroup(id string) (group TagGroup, err error) {
+	err = db.DB.One("ID", id, &group)
+	return
 }
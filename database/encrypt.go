@@ -0,0 +1,239 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/ahui2016/uglynotes/encrypt"
+	"github.com/ahui2016/uglynotes/settings"
+	"github.com/asdine/storm/v3"
+)
+
+const encryptionKey = "encryption-key"
+
+// ErrLocked 数据已加密，但尚未输入 passphrase.
+var ErrLocked = errors.New("数据已加密，请先登入并输入 passphrase")
+
+// Encrypted reports whether the note data is (or should be) encrypted.
+// 只要数据库中保存有加密参数，即使 settings 中已关闭加密，也需要 passphrase 来解密旧数据。
+func (db *DB) Encrypted() bool {
+	_, err := db.encryptionParams()
+	return err == nil || settings.Config.EncryptAtRest
+}
+
+// DataLocked reports whether the passphrase is required before reading notes.
+func (db *DB) DataLocked() bool {
+	return db.Encrypted() && db.key == nil
+}
+
+func (db *DB) encryptionParams() (params encrypt.Params, err error) {
+	err = db.DB.Get(metadataBucket, encryptionKey, &params)
+	return
+}
+
+// UnlockData 用 passphrase 推导密钥（密钥只保存在内存中）。
+// 第一次启用加密时，会生成新的参数并加密全部已有的笔记；
+// 如果 settings 中已关闭加密，则解密全部笔记并删除加密参数。
+func (db *DB) UnlockData(passphrase string) error {
+	if !db.Encrypted() || db.key != nil {
+		return nil
+	}
+	params, err := db.encryptionParams()
+	if err == storm.ErrNotFound {
+		return db.enableEncryption(passphrase)
+	}
+	if err != nil {
+		return err
+	}
+	key, err := encrypt.OpenKey(passphrase, params)
+	if err != nil {
+		return err
+	}
+	db.key = key
+	if !settings.Config.EncryptAtRest {
+		return db.disableEncryption()
+	}
+	return nil
+}
+
+func (db *DB) enableEncryption(passphrase string) error {
+	key, params, err := encrypt.NewKey(passphrase)
+	if err != nil {
+		return err
+	}
+	db.key = key
+	if err := db.reEncryptAll(db.key, params); err != nil {
+		db.key = nil
+		return err
+	}
+	return nil
+}
+
+func (db *DB) disableEncryption() error {
+	tx := db.mustBegin()
+	defer tx.Rollback()
+
+	notes, err := db.txDecryptedNotes(tx)
+	if err != nil {
+		return err
+	}
+	for i := range notes {
+		if err := tx.Update(&notes[i]); err != nil {
+			return err
+		}
+	}
+	if err := tx.Delete(metadataBucket, encryptionKey); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.key = nil
+	return nil
+}
+
+// RotateKey 用新的 passphrase 重新加密全部笔记。
+func (db *DB) RotateKey(oldPassphrase, newPassphrase string) error {
+	params, err := db.encryptionParams()
+	if err == storm.ErrNotFound {
+		return errors.New("尚未启用加密")
+	}
+	if err != nil {
+		return err
+	}
+	if db.key, err = encrypt.OpenKey(oldPassphrase, params); err != nil {
+		return err
+	}
+	newKey, newParams, err := encrypt.NewKey(newPassphrase)
+	if err != nil {
+		return err
+	}
+	if err := db.reEncryptAll(newKey, newParams); err != nil {
+		return err
+	}
+	db.key = newKey
+	return nil
+}
+
+// reEncryptAll 用 db.key 解密全部笔记，再用 newKey 加密，并保存 newParams.
+func (db *DB) reEncryptAll(newKey *encrypt.Key, newParams encrypt.Params) error {
+	tx := db.mustBegin()
+	defer tx.Rollback()
+
+	notes, err := db.txDecryptedNotes(tx)
+	if err != nil {
+		return err
+	}
+	for i := range notes {
+		if err := tx.Update(encryptNote(newKey, &notes[i])); err != nil {
+			return err
+		}
+	}
+	if err := tx.Set(metadataBucket, encryptionKey, &newParams); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) txDecryptedNotes(tx storm.Node) (notes []Note, err error) {
+	if err = tx.All(&notes); err != nil {
+		return
+	}
+	err = db.decryptNotes(notes)
+	return
+}
+
+// encrypted 返回 note 的加密副本（不修改 note 本身），未启用加密时直接返回 note.
+// 启用了加密但尚未输入 passphrase 时返回 ErrLocked, 以免把明文写进数据库。
+func (db *DB) encrypted(note *Note) (*Note, error) {
+	if db.key == nil {
+		if db.Encrypted() {
+			return nil, ErrLocked
+		}
+		return note, nil
+	}
+	return encryptNote(db.key, note), nil
+}
+
+func encryptNote(key *encrypt.Key, note *Note) *Note {
+	copied := *note
+	copied.Title = key.Encrypt(note.Title, note.ID)
+	copied.Patches = make([]string, len(note.Patches))
+	for i, patch := range note.Patches {
+		copied.Patches[i] = key.Encrypt(patch, note.ID)
+	}
+	copied.Milestones = make([]Milestone, len(note.Milestones))
+	for i, milestone := range note.Milestones {
+		milestone.Label = key.Encrypt(milestone.Label, note.ID)
+		copied.Milestones[i] = milestone
+	}
+	return &copied
+}
+
+// decryptNote 解密 note 的标题、内容与里程碑名称，未加密的数据保持原样。
+func (db *DB) decryptNote(note *Note) (err error) {
+	if note.Title, err = db.decrypt(note.Title, note.ID); err != nil {
+		return
+	}
+	for i := range note.Patches {
+		if note.Patches[i], err = db.decrypt(note.Patches[i], note.ID); err != nil {
+			return
+		}
+	}
+	for i := range note.Milestones {
+		label := &note.Milestones[i].Label
+		if *label, err = db.decrypt(*label, note.ID); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// DecryptNotes 解密从备份文件读取的笔记 (启用加密时导出的是密文)。
+func (db *DB) DecryptNotes(notes []Note) error {
+	return db.decryptNotes(notes)
+}
+
+// EncryptNotes 把从备份文件读取的笔记 (明文或密文) 改为用当前的 key 加密，
+// 以便写进 db2 (未启用加密时改为明文)。尚未输入 passphrase 时返回 ErrLocked.
+func (db *DB) EncryptNotes(notes []Note) error {
+	if err := db.decryptNotes(notes); err != nil {
+		return err
+	}
+	for i := range notes {
+		encrypted, err := db.encrypted(&notes[i])
+		if err != nil {
+			return err
+		}
+		notes[i] = *encrypted
+	}
+	return nil
+}
+
+// DecryptTitles 解密从 db2 读取的笔记的标题 (db2 返回的笔记不含内容)。
+func (db *DB) DecryptTitles(notes []*Note) (err error) {
+	for _, note := range notes {
+		if note.Title, err = db.decrypt(note.Title, note.ID); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (db *DB) decryptNotes(notes []Note) error {
+	for i := range notes {
+		if err := db.decryptNote(&notes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) decrypt(s, ad string) (string, error) {
+	if !encrypt.IsEncrypted(s) {
+		return s, nil
+	}
+	if db.key == nil {
+		return "", ErrLocked
+	}
+	return db.key.Decrypt(s, ad)
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ahui2016/uglynotes/encrypt"
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/ahui2016/uglynotes/settings"
+)
+
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	db := new(DB)
+	if err := db.Open(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func insertTestNote(t *testing.T, db *DB, contents string) *Note {
+	t.Helper()
+	note := db.NewNote(model.Markdown)
+	err1 := note.AddPatchSetTitle(model.MakePatch("", contents), contents)
+	err2 := note.SetTags([]string{"a", "b"})
+	err3 := db.Insert(note)
+	for _, err := range []error{err1, err2, err3} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return note
+}
+
+func TestEncryptAtRestAndRotateKey(t *testing.T) {
+	defer func(old bool) { settings.Config.EncryptAtRest = old }(settings.Config.EncryptAtRest)
+	settings.Config.EncryptAtRest = false
+
+	db := openTestDB(t)
+	note := insertTestNote(t, db, "plain title")
+
+	settings.Config.EncryptAtRest = true
+	if !db.DataLocked() {
+		t.Fatal("DataLocked() = false before the passphrase is entered")
+	}
+	if _, err := db.encrypted(note); err != ErrLocked {
+		t.Fatalf("encrypted() without key: err = %v, want ErrLocked", err)
+	}
+	if err := db.UnlockData("old passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	assertStoredEncrypted(t, db, note.ID)
+
+	if err := db.RotateKey("wrong passphrase", "new passphrase"); err != encrypt.ErrWrongPassphrase {
+		t.Fatalf("RotateKey(wrong passphrase) err = %v", err)
+	}
+	if err := db.RotateKey("old passphrase", "new passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	assertStoredEncrypted(t, db, note.ID)
+
+	// 重新登入：旧的 passphrase 不再有效。
+	db.key = nil
+	if err := db.UnlockData("old passphrase"); err != encrypt.ErrWrongPassphrase {
+		t.Fatalf("UnlockData(old passphrase) err = %v", err)
+	}
+	if err := db.UnlockData("new passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetByID(note.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := got.CurrentContents()
+	if err != nil || got.Title != "plain title" || contents != "plain title" {
+		t.Errorf("after rotation: title %q, contents %q, err %v", got.Title, contents, err)
+	}
+}
+
+func assertStoredEncrypted(t *testing.T, db *DB, id string) {
+	t.Helper()
+	var raw Note
+	if err := db.DB.One("ID", id, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if !encrypt.IsEncrypted(raw.Title) || !encrypt.IsEncrypted(raw.Patches[0]) {
+		t.Errorf("stored note is not encrypted: title %q", raw.Title)
+	}
+}
+
+func TestImportNotesIntoDB2Encrypted(t *testing.T) {
+	defer func(old bool) { settings.Config.EncryptAtRest = old }(settings.Config.EncryptAtRest)
+	settings.Config.EncryptAtRest = true
+
+	db := openTestDB(t)
+	if err := db.UnlockData("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	note := insertTestNote(t, db, "secret title")
+	notes, err := db.RawNotesWithDeleted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EncryptNotes(notes); err != nil {
+		t.Fatal(err)
+	}
+
+	path2 := filepath.Join(t.TempDir(), "test.db2")
+	db2 := new(DB2)
+	if err := db2.Open(path2); err != nil {
+		t.Fatal(err)
+	}
+	err1 := db2.ImportNotes(notes)
+	imported, err2 := db2.AllNotes()
+	err3 := db.DecryptTitles(imported)
+	err4 := db2.Close()
+	for _, err := range []error{err1, err2, err3, err4} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(imported) != 1 || imported[0].ID != note.ID || imported[0].Title != "secret title" {
+		t.Errorf("db2.AllNotes() = %+v", imported)
+	}
+	blob, err := ioutil.ReadFile(path2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(blob, []byte("secret title")) {
+		t.Error("db2 file contains the plaintext title")
+	}
+}
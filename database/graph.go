@@ -0,0 +1,151 @@
+package database
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/ahui2016/uglynotes/stringset"
+	"github.com/asdine/storm/v3"
+)
+
+// 知识图谱中节点与边的类型
+const (
+	GraphNote     = "note"
+	GraphTag      = "tag"
+	GraphLink     = "link"     // 笔记 → 笔记
+	GraphTagged   = "tagged"   // 笔记 → 标签
+	GraphCooccurs = "cooccurs" // 标签 — 标签 (同时出现在同一篇笔记中)
+)
+
+// GraphNode 知识图谱中的一个节点 (笔记或标签)。
+type GraphNode struct {
+	ID     string // "note:<id>" 或 "tag:<name>"
+	Type   string
+	Label  string // 笔记的标题或标签名称
+	Weight int    // 笔记的体积，或标签的笔记数量
+}
+
+// GraphEdge 知识图谱中的一条边，Weight 是链接的数量或标签同时出现的次数。
+type GraphEdge struct {
+	Source string
+	Target string
+	Type   string
+	Weight int
+}
+
+// Graph 知识图谱。
+type Graph struct {
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+// GraphFilter 只包括含有全部 Tags (或其子标签) 且更新时间在 [Since, Until] 之内的笔记，
+// 零值表示不限。
+type GraphFilter struct {
+	Tags  []string
+	Since time.Time
+	Until time.Time
+}
+
+func (filter *GraphFilter) match(note *Note) bool {
+	for _, tag := range filter.Tags {
+		if !model.HasTagUnder(note.Tags, tag) {
+			return false
+		}
+	}
+	if filter.Since.IsZero() && filter.Until.IsZero() {
+		return true
+	}
+	updated, err := model.ParseTime(note.UpdatedAt)
+	if err != nil {
+		return false
+	}
+	return (filter.Since.IsZero() || !updated.Before(filter.Since)) &&
+		(filter.Until.IsZero() || !updated.After(filter.Until))
+}
+
+// Graph 根据标签与链接数据生成知识图谱 (不包括回收站中的笔记)，
+// 不需要把笔记内容发送给浏览器。
+func (db *DB) Graph(filter GraphFilter) (graph Graph, err error) {
+	if filter.Tags, err = db.ResolveTags(filter.Tags); err != nil {
+		return
+	}
+	notes, err := db.AllNotes()
+	if err != nil && err != storm.ErrNotFound {
+		return
+	}
+	var links []NoteLink
+	if err = db.DB.All(&links); err != nil {
+		return
+	}
+
+	graph.Nodes, graph.Edges = []GraphNode{}, []GraphEdge{}
+	included := make(map[string]bool)
+	tagCount := make(map[string]int)
+	cooccurs := make(map[[2]string]int)
+	for i := range notes {
+		note := &notes[i]
+		if !filter.match(note) {
+			continue
+		}
+		included[note.ID] = true
+		graph.Nodes = append(graph.Nodes, GraphNode{
+			ID: "note:" + note.ID, Type: GraphNote, Label: note.Title, Weight: note.Size,
+		})
+		tags := stringset.UniqueSort(note.Tags)
+		for j, tag := range tags {
+			tagCount[tag]++
+			graph.Edges = append(graph.Edges, GraphEdge{
+				Source: "note:" + note.ID, Target: "tag:" + tag, Type: GraphTagged, Weight: 1,
+			})
+			for _, other := range tags[j+1:] {
+				cooccurs[[2]string{tag, other}]++
+			}
+		}
+	}
+
+	tagNames := make([]string, 0, len(tagCount))
+	for name := range tagCount {
+		tagNames = append(tagNames, name)
+	}
+	sort.Strings(tagNames)
+	for _, name := range tagNames {
+		graph.Nodes = append(graph.Nodes, GraphNode{
+			ID: "tag:" + name, Type: GraphTag, Label: name, Weight: tagCount[name],
+		})
+	}
+
+	linkCount := make(map[[2]string]int)
+	for _, link := range links {
+		if !link.Broken && included[link.From] && included[link.To] {
+			linkCount[[2]string{link.From, link.To}]++
+		}
+	}
+	graph.Edges = appendEdges(graph.Edges, linkCount, "note:", GraphLink)
+	graph.Edges = appendEdges(graph.Edges, cooccurs, "tag:", GraphCooccurs)
+	return graph, nil
+}
+
+// appendEdges 按 source, target 排序后添加到 edges 中，使结果稳定。
+func appendEdges(edges []GraphEdge, counts map[[2]string]int, prefix, edgeType string) []GraphEdge {
+	pairs := make([][2]string, 0, len(counts))
+	for pair := range counts {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	for _, pair := range pairs {
+		edges = append(edges, GraphEdge{
+			Source: prefix + pair[0],
+			Target: prefix + pair[1],
+			Type:   edgeType,
+			Weight: counts[pair],
+		})
+	}
+	return edges
+}
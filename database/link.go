@@ -0,0 +1,454 @@
+package database
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+)
+
+// NoteLink = model.NoteLink
+type NoteLink = model.NoteLink
+
+// OutLink 笔记中的一个链接及其目标笔记。
+type OutLink struct {
+	Target string // 链接中的文字 (id 或标题)
+	ID     string // 目标笔记的 id, 找不到目标时为空字符串
+	Title  string
+	Broken bool
+}
+
+// titleIndex 笔记标题 (小写) 到 id 的索引，只保存在内存中 (以免泄露加密的标题)，
+// 使按标题查找链接的目标时不需要读取并解密全部笔记。
+// 索引只用于缩小查找范围，找到的笔记会再从数据库读取并核对标题，
+// 因此索引中多余的 id 不影响结果。
+//
+// 索引只反映已提交的数据：事务中改变的标题先用 stage 记录 (查找时可见)，
+// 事务提交后用 commit 写进索引，否则用 discard 丢弃。
+type titleIndex struct {
+	sync.Mutex
+	loaded  bool
+	ids     map[string]map[string]bool // 小写标题 → 笔记 id
+	titles  map[string]string          // 笔记 id → 小写标题
+	pending map[string]string          // 尚未提交的 笔记 id → 小写标题
+}
+
+// stage 记录笔记在当前事务中的新标题。
+func (idx *titleIndex) stage(id, title string) {
+	idx.Lock()
+	defer idx.Unlock()
+	if idx.pending == nil {
+		idx.pending = make(map[string]string)
+	}
+	idx.pending[id] = strings.ToLower(title)
+}
+
+// commit 在事务提交后把 stage 记录的标题写进索引。
+// 索引尚未建立时不需要写进去，建立索引时会读取全部笔记。
+func (idx *titleIndex) commit() {
+	idx.Lock()
+	defer idx.Unlock()
+	if idx.loaded {
+		for id, title := range idx.pending {
+			idx.add(id, title)
+		}
+	}
+	idx.pending = nil
+}
+
+// discard 丢弃 stage 记录的标题 (事务已提交时 pending 为空，因此可以放在 defer 中)。
+func (idx *titleIndex) discard() {
+	idx.Lock()
+	defer idx.Unlock()
+	idx.pending = nil
+}
+
+// reset 清空索引，下次查找时重新建立。
+func (idx *titleIndex) reset() {
+	idx.Lock()
+	defer idx.Unlock()
+	idx.loaded, idx.ids, idx.titles = false, nil, nil
+}
+
+// add 必须在 idx.Lock() 之后调用，title 必须是小写。
+func (idx *titleIndex) add(id, title string) {
+	if old, ok := idx.titles[id]; ok {
+		delete(idx.ids[old], id)
+	}
+	if idx.ids[title] == nil {
+		idx.ids[title] = make(map[string]bool)
+	}
+	idx.ids[title][id] = true
+	idx.titles[id] = title
+}
+
+// titleIDs 返回标题可能是 title (不区分大小写) 的笔记的 id, 有需要时先建立索引。
+// 建立索引时读取的是已提交的数据 (不使用当前事务)，以免把未提交的标题写进索引。
+func (db *DB) titleIDs(title string) (ids []string, err error) {
+	idx := &db.titles
+	idx.Lock()
+	defer idx.Unlock()
+	if !idx.loaded {
+		var notes []Note
+		if err := db.DB.All(&notes); err != nil {
+			return nil, err
+		}
+		idx.ids = make(map[string]map[string]bool)
+		idx.titles = make(map[string]string)
+		for i := range notes {
+			title, err := db.decrypt(notes[i].Title, notes[i].ID)
+			if err != nil {
+				return nil, err
+			}
+			idx.add(notes[i].ID, strings.ToLower(title))
+		}
+		idx.loaded = true
+	}
+	title = strings.ToLower(title)
+	for id := range idx.ids[title] {
+		ids = append(ids, id)
+	}
+	for id, pending := range idx.pending {
+		if pending == title && !idx.ids[title][id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// linkResolver 根据 id 或标题查找链接的目标笔记。
+type linkResolver struct {
+	db *DB
+	tx storm.Node
+}
+
+// resolve 先按 id 查找，再按标题查找（不区分大小写），找不到时返回 nil.
+// 有多篇同名笔记时，优先选择未删除的、最近更新的笔记。
+func (r *linkResolver) resolve(target string) (*Note, error) {
+	var note Note
+	err := r.tx.One("ID", strings.ToLower(target), &note)
+	if err == nil {
+		note.Title, err = r.db.decrypt(note.Title, note.ID)
+		return &note, err
+	}
+	if err != storm.ErrNotFound {
+		return nil, err
+	}
+	ids, err := r.db.titleIDs(target)
+	if err != nil {
+		return nil, err
+	}
+	var found *Note
+	for _, id := range ids {
+		n := new(Note)
+		err := r.tx.One("ID", id, n)
+		if err == storm.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n.Title, err = r.db.decrypt(n.Title, n.ID); err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(n.Title, target) {
+			continue
+		}
+		if found == nil || (found.Deleted && !n.Deleted) ||
+			(found.Deleted == n.Deleted && n.UpdatedAt > found.UpdatedAt) {
+			found = n
+		}
+	}
+	return found, nil
+}
+
+func (db *DB) txOutLinks(tx storm.Node, contents string) (links []OutLink, err error) {
+	r := &linkResolver{db: db, tx: tx}
+	for _, target := range model.WikiLinks(contents) {
+		link := OutLink{Target: target, Broken: true}
+		found, err := r.resolve(target)
+		if err != nil {
+			return nil, err
+		}
+		if found != nil {
+			link.ID, link.Title, link.Broken = found.ID, found.Title, found.Deleted
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// OutLinks 返回笔记中的全部链接及其目标。客户端加密的笔记无法解析内容，因此没有链接。
+func (db *DB) OutLinks(id string) ([]OutLink, error) {
+	note, err := db.GetByID(id)
+	if err != nil || note.IsEncrypted() {
+		return nil, err
+	}
+	contents, err := note.CurrentContents()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.DB.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return db.txOutLinks(tx, contents)
+}
+
+// BackLinks 返回链接到该笔记的全部笔记 (不含内容)。
+func (db *DB) BackLinks(id string) (notes []Note, err error) {
+	var links []NoteLink
+	err = db.DB.Find("To", id, &links)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, link := range links {
+		if seen[link.From] {
+			continue
+		}
+		seen[link.From] = true
+		note, err := db.GetByID(link.From)
+		if err != nil {
+			return nil, err
+		}
+		note.Patches = nil
+		notes = append(notes, note)
+	}
+	return notes, nil
+}
+
+// RebuildLinks 根据全部笔记的最新内容重新生成全部链接。
+func (db *DB) RebuildLinks() error {
+	tx := db.mustBegin()
+	defer tx.Rollback()
+
+	if err := tx.Drop(&NoteLink{}); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	if err := tx.Init(&NoteLink{}); err != nil {
+		return err
+	}
+	db.titles.reset()
+	notes, err := db.txDecryptedNotes(tx)
+	if err != nil {
+		return err
+	}
+	for i := range notes {
+		if err := db.txUpdateLinks(tx, &notes[i]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// txUpdateLinks 根据 note 的最新内容重新生成从该笔记出发的全部链接。
+// 内容无法还原时（例如 patch 损坏）不生成链接，以免影响笔记本身的修改。
+func (db *DB) txUpdateLinks(tx storm.Node, note *Note) error {
+	if err := txDeleteLinks(tx, q.Eq("From", note.ID)); err != nil {
+		return err
+	}
+	if note.IsEncrypted() {
+		return nil
+	}
+	contents, err := note.CurrentContents()
+	if err != nil {
+		return nil
+	}
+	return db.txSaveLinks(tx, note.ID, contents)
+}
+
+// txSaveLinks 解析 contents 中的链接并保存 (不删除旧的链接)。
+func (db *DB) txSaveLinks(tx storm.Node, id, contents string) error {
+	links, err := db.txOutLinks(tx, contents)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if err := tx.Save(model.NewNoteLink(id, link.ID, link.Broken)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func txDeleteLinks(tx storm.Node, matcher q.Matcher) error {
+	err := tx.Select(matcher).Delete(&NoteLink{})
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return err
+}
+
+// txSetLinksBroken 把指向该笔记的链接标记为断开（放进回收站时）或恢复（取消删除时）。
+func txSetLinksBroken(tx storm.Node, id string, broken bool) error {
+	var links []NoteLink
+	err := tx.Find("To", id, &links)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := range links {
+		if err := tx.UpdateField(&links[i], "Broken", broken); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// txUnlinkNote 彻底删除笔记时，删除从该笔记出发的链接，指向该笔记的链接则变为断开的链接。
+func txUnlinkNote(tx storm.Node, id string) error {
+	if err := txDeleteLinks(tx, q.Eq("From", id)); err != nil {
+		return err
+	}
+	var links []NoteLink
+	err := tx.Find("To", id, &links)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := range links {
+		links[i].To, links[i].Broken = "", true
+		if err := tx.Save(&links[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// txResolveBrokenLinks 新增笔记或标题改变后，原本找不到目标的链接可能已经能找到 note,
+// 因此重新生成含有 [[note 的 id 或标题]] 且含有断开链接的笔记的链接，其他笔记不受影响。
+func (db *DB) txResolveBrokenLinks(tx storm.Node, note *Note) error {
+	var links []NoteLink
+	err := tx.Find("Broken", true, &links)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, link := range links {
+		if seen[link.From] {
+			continue
+		}
+		seen[link.From] = true
+		var source Note
+		if err := tx.One("ID", link.From, &source); err != nil {
+			return err
+		}
+		if err := db.decryptNote(&source); err != nil {
+			return err
+		}
+		if source.IsEncrypted() {
+			continue
+		}
+		contents, err := source.CurrentContents()
+		if err != nil || !linksTo(contents, note) {
+			continue
+		}
+		if err := txDeleteLinks(tx, q.Eq("From", source.ID)); err != nil {
+			return err
+		}
+		if err := db.txSaveLinks(tx, source.ID, contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linksTo 判断 contents 中是否有按 id 或标题指向 note 的链接。
+func linksTo(contents string, note *Note) bool {
+	for _, target := range model.WikiLinks(contents) {
+		if strings.EqualFold(target, note.ID) || strings.EqualFold(target, note.Title) {
+			return true
+		}
+	}
+	return false
+}
+
+// RenamedLinks 笔记的标题改变后，因 [[旧标题]] 改为 [[新标题]] 而被修改的笔记，
+// 以及未能修改的笔记 (详见 txRenameLinks)。
+type RenamedLinks struct {
+	Updated []string
+	Skipped []string
+}
+
+// txRenameLinks 笔记的标题改变后，把其他笔记中的 [[旧标题]] 改为 [[新标题]],
+// 每篇被修改的笔记都会产生一个新的历史版本。客户端加密的、超过体积上限的、
+// 内容无法还原的笔记，以及会超过数据库总容量上限的修改都会被跳过。
+func (db *DB) txRenameLinks(tx storm.Node, note *Note, oldTitle, session string) (renamed RenamedLinks, err error) {
+	if note.IsEncrypted() || !model.CanBeLinkTarget(oldTitle) ||
+		!model.CanBeLinkTarget(note.Title) {
+		return
+	}
+	var links []NoteLink
+	err = tx.Find("To", note.ID, &links)
+	if err == storm.ErrNotFound {
+		return renamed, nil
+	}
+	if err != nil {
+		return
+	}
+	seen := map[string]bool{note.ID: true}
+	for _, link := range links {
+		if seen[link.From] {
+			continue
+		}
+		seen[link.From] = true
+		var source Note
+		if err = tx.One("ID", link.From, &source); err != nil {
+			return
+		}
+		if err = db.decryptNote(&source); err != nil {
+			return
+		}
+		if source.IsEncrypted() {
+			renamed.Skipped = append(renamed.Skipped, source.ID)
+			continue
+		}
+		contents, err := source.CurrentContents()
+		if err != nil {
+			renamed.Skipped = append(renamed.Skipped, source.ID)
+			continue
+		}
+		updated := model.ReplaceWikiLinks(contents, oldTitle, note.Title)
+		if updated == contents {
+			continue
+		}
+		patch := model.MakePatch(contents, updated)
+		if err := source.AddPatchNow(patch, strings.TrimSpace(updated)); err != nil {
+			renamed.Skipped = append(renamed.Skipped, source.ID)
+			continue
+		}
+		err = txCheckIncreaseTotalSize(tx, len(patch))
+		if err == errOverCapacity {
+			renamed.Skipped = append(renamed.Skipped, source.ID)
+			continue
+		}
+		if err != nil {
+			return renamed, err
+		}
+		source.SetVersionSession(session)
+		encrypted, err := db.encrypted(&source)
+		if err != nil {
+			return renamed, err
+		}
+		if err = tx.Update(encrypted); err != nil {
+			return renamed, err
+		}
+		db.titles.stage(source.ID, source.Title)
+		renamed.Updated = append(renamed.Updated, source.ID)
+	}
+	return renamed, nil
+}
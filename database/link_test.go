@@ -0,0 +1,75 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/ahui2016/uglynotes/settings"
+)
+
+func TestRenameLinks(t *testing.T) {
+	db := openTestDB(t)
+	target := insertTestNote(t, db, "Target")
+	source := insertTestNote(t, db, "Source\n[[target]]")
+
+	count, renamed, err := db.AddPatchSetTitle(
+		target.ID, model.MakePatch("Target", "Renamed"), "Renamed", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || len(renamed.Updated) != 1 || renamed.Updated[0] != source.ID {
+		t.Fatalf("AddPatchSetTitle() = %d, %+v", count, renamed)
+	}
+	assertContents(t, db, source.ID, "Source\n[[Renamed]]")
+	assertOutLink(t, db, source.ID, target.ID)
+}
+
+func TestRenameLinksOverCapacity(t *testing.T) {
+	defer func(old int) { settings.Config.DatabaseCapacity = old }(settings.Config.DatabaseCapacity)
+
+	db := openTestDB(t)
+	target := insertTestNote(t, db, "Target")
+	source := insertTestNote(t, db, "Source\n[[Target]]")
+
+	// 容量只够修改 target 本身，不够修改 source 中的链接。
+	patch := model.MakePatch("Target", "Renamed")
+	total, err := db.GetTotalSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.Config.DatabaseCapacity = total + len(patch)
+
+	_, renamed, err := db.AddPatchSetTitle(target.ID, patch, "Renamed", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renamed.Updated) != 0 || len(renamed.Skipped) != 1 || renamed.Skipped[0] != source.ID {
+		t.Fatalf("renamed = %+v, want source skipped", renamed)
+	}
+	if got, err := db.GetTotalSize(); err != nil || got != total+len(patch) {
+		t.Errorf("total size = %d, %v, want %d", got, err, total+len(patch))
+	}
+	assertContents(t, db, source.ID, "Source\n[[Target]]")
+}
+
+func assertContents(t *testing.T, db *DB, id, want string) {
+	t.Helper()
+	note, err := db.GetByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := note.CurrentContents(); err != nil || got != want {
+		t.Errorf("contents of %s = %q, %v, want %q", id, got, err, want)
+	}
+}
+
+func assertOutLink(t *testing.T, db *DB, from, to string) {
+	t.Helper()
+	links, err := db.OutLinks(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0].ID != to || links[0].Broken {
+		t.Errorf("OutLinks(%s) = %+v, want a link to %s", from, links, to)
+	}
+}
@@ -18,6 +18,8 @@ const (
 	totalSizeKey   = "total-size-key"
 )
 
+var errOverCapacity = errors.New("超过数据库总容量上限")
+
 type TX interface {
 	Exec(string, ...interface{}) (sql.Result, error)
 	QueryRow(string, ...interface{}) *sql.Row
@@ -132,7 +134,7 @@ func txCheckTotalSize(tx storm.Node, addition int) error {
 		return err
 	}
 	if totalSize+addition > settings.Config.DatabaseCapacity {
-		return errors.New("超过数据库总容量上限")
+		return errOverCapacity
 	}
 	return nil
 }
@@ -152,10 +154,12 @@ func txIncreaseTotalSize(tx storm.Node, addition int) error {
 	return txSetTotalSize(tx, totalSize+addition)
 }
 
+// txCheckIncreaseTotalSize 检查总容量，未超过上限时才增加总体积。
 func txCheckIncreaseTotalSize(tx storm.Node, addition int) error {
-	err1 := txCheckTotalSize(tx, addition)
-	err2 := txIncreaseTotalSize(tx, addition)
-	return util.WrapErrors(err1, err2)
+	if err := txCheckTotalSize(tx, addition); err != nil {
+		return err
+	}
+	return txIncreaseTotalSize(tx, addition)
 }
 
 // resetTotalSize 用于一次性删除多个项目时重新计算数据库总体积。
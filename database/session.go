@@ -1,6 +1,19 @@
 package database
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// sessionTwoFactor 记录当前会话是否已通过两步验证。
+	sessionTwoFactor = "uglynotes2FA"
+
+	// sessionCSRF 保存当前会话的 CSRF token.
+	sessionCSRF = "uglynotesCSRF"
+)
 
 // SessionCheck .
 func (db *DB) SessionCheck(c *fiber.Ctx) bool {
@@ -12,12 +25,77 @@ func (db *DB) SessionCheck(c *fiber.Ctx) bool {
 	return sess.Get(cookieName).(bool)
 }
 
-// SessionSet .
-func (db *DB) SessionSet(c *fiber.Ctx) error {
+// SessionSet 登入，其中 twoFactor 表示本次登入是否已通过两步验证，
+// csrfToken 与会话绑定，此后 /api 下的全部非 GET 请求都需要提交该 token.
+func (db *DB) SessionSet(c *fiber.Ctx, twoFactor bool, csrfToken string) error {
 	sess, err := db.Sess.Get(c)
 	if err != nil {
 		return err
 	}
 	sess.Set(cookieName, true)
+	sess.Set(sessionTwoFactor, twoFactor)
+	sess.Set(sessionCSRF, csrfToken)
+	c.Locals(cookieName, sess.ID()) // 登入时请求中还没有 cookie, 因此暂存 session id
+	return sess.Save()
+}
+
+// SessionTwoFactor reports whether the session has passed 2FA.
+func (db *DB) SessionTwoFactor(c *fiber.Ctx) bool {
+	sess, err := db.Sess.Get(c)
+	if err != nil || sess.Get(sessionTwoFactor) == nil {
+		return false
+	}
+	return sess.Get(sessionTwoFactor).(bool)
+}
+
+// SessionSetTwoFactor 标记当前会话已通过两步验证。
+func (db *DB) SessionSetTwoFactor(c *fiber.Ctx) error {
+	sess, err := db.Sess.Get(c)
+	if err != nil {
+		return err
+	}
+	sess.Set(sessionTwoFactor, true)
 	return sess.Save()
 }
+
+// SessionCSRF 返回当前会话的 CSRF token, 如果没有则返回空字符串。
+func (db *DB) SessionCSRF(c *fiber.Ctx) string {
+	sess, err := db.Sess.Get(c)
+	if err != nil || sess.Get(sessionCSRF) == nil {
+		return ""
+	}
+	return sess.Get(sessionCSRF).(string)
+}
+
+// SessionSetCSRF 为当前会话设置 CSRF token (用于升级前已登入的会话)。
+func (db *DB) SessionSetCSRF(c *fiber.Ctx, csrfToken string) error {
+	sess, err := db.Sess.Get(c)
+	if err != nil {
+		return err
+	}
+	sess.Set(sessionCSRF, csrfToken)
+	return sess.Save()
+}
+
+// SessionLabel 返回当前会话的标识 (会话 ID 的 hash 的开头部分)，
+// 可以区分不同的会话，又不会泄露会话 ID.
+func (db *DB) SessionLabel(c *fiber.Ctx) string {
+	id := db.SessionID(c)
+	if id == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:6])
+}
+
+// SessionID 返回当前会话的 ID (会话 ID 不可泄露，记录时请使用 SessionLabel)。
+func (db *DB) SessionID(c *fiber.Ctx) string {
+	if id, ok := c.Locals(cookieName).(string); ok {
+		return id
+	}
+	sess, err := db.Sess.Get(c)
+	if err != nil {
+		return ""
+	}
+	return sess.ID()
+}
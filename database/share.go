@@ -0,0 +1,95 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+)
+
+// Share = model.Share
+type Share = model.Share
+
+// ErrShareNotFound 分享链接不存在、已撤销或已过期。
+var ErrShareNotFound = errors.New("share link not found or expired")
+
+// AddShare 为笔记创建分享链接。客户端加密的笔记无法在服务器端显示，因此不能分享。
+func (db *DB) AddShare(share *Share) error {
+	note, err := db.GetByID(share.NoteID)
+	if err != nil {
+		return fmt.Errorf("id[%s] %w", share.NoteID, err)
+	}
+	if note.IsEncrypted() {
+		return errors.New("不能分享加密笔记")
+	}
+	if share.Version < 0 || share.Version > len(note.Patches) {
+		return fmt.Errorf("version %d out of range [0, %d]",
+			share.Version, len(note.Patches))
+	}
+	return db.DB.Save(share)
+}
+
+// NoteShares 返回一篇笔记的全部分享链接。
+func (db *DB) NoteShares(noteID string) (shares []Share, err error) {
+	err = db.DB.Select(q.Eq("NoteID", noteID)).OrderBy("CreatedAt").Find(&shares)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+// GetShare .
+func (db *DB) GetShare(token string) (share Share, err error) {
+	err = db.DB.One("Token", token, &share)
+	return
+}
+
+// DeleteShare 撤销分享链接。
+func (db *DB) DeleteShare(token string) error {
+	return db.DB.DeleteStruct(&Share{Token: token})
+}
+
+// OpenShare 通过分享链接获取笔记内容，同时记录访问次数。
+// 已删除（包括放进回收站）的笔记不能通过分享链接访问。
+func (db *DB) OpenShare(token string) (
+	share Share, note Note, contents string, err error) {
+
+	tx := db.mustBegin()
+	defer tx.Rollback()
+
+	if err = tx.One("Token", token, &share); err != nil || share.Expired() {
+		err = ErrShareNotFound
+		return
+	}
+	if err = tx.One("ID", share.NoteID, &note); err != nil || note.Deleted {
+		err = ErrShareNotFound
+		return
+	}
+	if err = db.decryptNote(&note); err != nil {
+		return
+	}
+	version := share.Version
+	if version == 0 {
+		version = len(note.Patches)
+	}
+	if contents, err = note.ContentsAt(version); err != nil {
+		return
+	}
+	share.AccessCount++
+	share.LastAccessAt = model.TimeNow()
+	if err = tx.Update(&share); err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+func txDeleteNoteShares(tx storm.Node, noteID string) error {
+	err := tx.Select(q.Eq("NoteID", noteID)).Delete(&Share{})
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return err
+}
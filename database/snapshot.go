@@ -0,0 +1,142 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+)
+
+// NoteChange = model.NoteChange
+type NoteChange = model.NoteChange
+
+// ErrNoteNotExistAt 在指定的时刻，笔记尚未创建（或该时刻之前的版本都已被压缩）。
+var ErrNoteNotExistAt = errors.New("the note did not exist at that time")
+
+// txLogNoteChange 记录笔记的标签与删除状态的变化，t 是变化发生的时间 (ISO8601)。
+func txLogNoteChange(tx storm.Node, note *Note, t string) error {
+	return tx.Save(model.NewNoteChange(note, t))
+}
+
+func txDeleteNoteChanges(tx storm.Node, noteID string) error {
+	err := tx.Select(q.Eq("NoteID", noteID)).Delete(&NoteChange{})
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return err
+}
+
+// NotesAt 还原 t 时刻的全部笔记，包括 t 之后才放进回收站的笔记
+// (但不包括已彻底删除的笔记)，按更新时间排序。
+// 内容详见 Note.At, 标签与删除状态取自 t 之前最后一次变化记录，
+// 没有记录时（例如升级前的笔记）使用当前的状态。
+func (db *DB) NotesAt(t time.Time) (notes []Note, err error) {
+	var all []Note
+	if err = db.DB.All(&all); err != nil {
+		return
+	}
+	if err = db.decryptNotes(all); err != nil {
+		return
+	}
+	var changes []NoteChange
+	if err = db.DB.All(&changes); err != nil {
+		return
+	}
+	latest := latestChanges(changes, t)
+	for i := range all {
+		note := all[i]
+		ok, err := note.At(t)
+		if err != nil {
+			return nil, fmt.Errorf("id[%s] %w", note.ID, err)
+		}
+		if !ok {
+			continue
+		}
+		if change, ok := latest[note.ID]; ok {
+			note.Tags, note.Deleted = change.Tags, change.Deleted
+		}
+		notes = append(notes, note)
+	}
+	sort.SliceStable(notes, func(i, j int) bool {
+		return notes[i].UpdatedAt < notes[j].UpdatedAt
+	})
+	return notes, nil
+}
+
+// NoteAt 还原 t 时刻的一篇笔记，详见 NotesAt.
+func (db *DB) NoteAt(id string, t time.Time) (note Note, err error) {
+	if note, err = db.GetByID(id); err != nil {
+		return
+	}
+	ok, err := note.At(t)
+	if err != nil {
+		return
+	}
+	if !ok {
+		return note, ErrNoteNotExistAt
+	}
+	var changes []NoteChange
+	err = db.DB.Find("NoteID", id, &changes)
+	if err != nil && err != storm.ErrNotFound {
+		return
+	}
+	if change, ok := latestChanges(changes, t)[id]; ok {
+		note.Tags, note.Deleted = change.Tags, change.Deleted
+	}
+	return note, nil
+}
+
+// latestChanges 返回每篇笔记在 t 之前 (含 t) 的最后一次变化。
+func latestChanges(changes []NoteChange, t time.Time) map[string]NoteChange {
+	// ID 基于时间，因此按 ID 排序即按记录的先后排序。
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].ID < changes[j].ID
+	})
+	latest := make(map[string]NoteChange)
+	for _, change := range changes {
+		changedAt, err := model.ParseTime(change.Time)
+		if err != nil || changedAt.After(t) {
+			continue
+		}
+		latest[change.NoteID] = change
+	}
+	return latest
+}
+
+// TagsAt 返回 t 时刻的全部标签（不包括回收站中的笔记），按名称排序。
+// 标签的创建时间取其中最早创建的笔记的创建时间。
+func (db *DB) TagsAt(t time.Time) (tags []Tag, err error) {
+	notes, err := db.NotesAt(t)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*Tag)
+	for _, note := range notes {
+		if note.Deleted {
+			continue
+		}
+		for _, name := range note.Tags {
+			tag, ok := byName[name]
+			if !ok {
+				byName[name] = model.NewTag(name, note.ID)
+				byName[name].CreatedAt = note.CreatedAt
+				continue
+			}
+			tag.Add(note.ID)
+			if note.CreatedAt < tag.CreatedAt {
+				tag.CreatedAt = note.CreatedAt
+			}
+		}
+	}
+	for _, tag := range byName {
+		tags = append(tags, *tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/asdine/storm/v3"
+)
+
+// TagNode = model.TagNode
+type TagNode = model.TagNode
+
+// txTagsUnder 返回该标签及其全部子标签，父标签本身可以不存在（只作为子标签的前缀）。
+// 一个都找不到时返回 storm.ErrNotFound.
+func txTagsUnder(tx storm.Node, name string) (tags []Tag, err error) {
+	var tag Tag
+	err = tx.One("Name", name, &tag)
+	if err != nil && err != storm.ErrNotFound {
+		return
+	}
+	if err == nil {
+		tags = append(tags, tag)
+	}
+	var children []Tag
+	err = tx.Prefix("Name", name+model.TagSep, &children)
+	if err != nil && err != storm.ErrNotFound {
+		return
+	}
+	if tags = append(tags, children...); len(tags) == 0 {
+		return nil, storm.ErrNotFound
+	}
+	return tags, nil
+}
+
+// noteIDsUnder 返回使用该标签或其子标签的全部笔记的 id (除重，保持原来的顺序)。
+func (db *DB) noteIDsUnder(name string) (ids []string, err error) {
+	tags, err := txTagsUnder(db.DB, name)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		for _, id := range tag.NoteIDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// TagTree 返回全部标签组成的树，详见 model.TagTree.
+func (db *DB) TagTree() ([]*TagNode, error) {
+	var tags []Tag
+	if err := db.DB.All(&tags); err != nil {
+		return nil, err
+	}
+	return model.TagTree(tags), nil
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/ahui2016/uglynotes/totp"
+	"github.com/ahui2016/uglynotes/util"
+	"github.com/asdine/storm/v3"
+)
+
+const (
+	twoFactorKey      = "two-factor-key"
+	recoveryCodeCount = 10
+)
+
+// TwoFactor = model.TwoFactor
+type TwoFactor = model.TwoFactor
+
+// GetTwoFactor 获取两步验证的状态，如果从未设置过，则返回零值。
+func (db *DB) GetTwoFactor() (tf TwoFactor, err error) {
+	err = db.DB.Get(metadataBucket, twoFactorKey, &tf)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+// TwoFactorEnabled .
+func (db *DB) TwoFactorEnabled() bool {
+	tf, err := db.GetTwoFactor()
+	util.Panic(err)
+	return tf.Enabled
+}
+
+// EnrollTwoFactor 生成新的密钥与恢复码，返回明文恢复码（只显示这一次）。
+// 此时尚未启用两步验证，需要使用 ConfirmTwoFactor 确认。
+func (db *DB) EnrollTwoFactor() (secret string, codes []string, err error) {
+	tf, err := db.GetTwoFactor()
+	if err != nil {
+		return
+	}
+	if tf.Enabled {
+		return "", nil, errors.New("两步验证已启用，如需重新设置请先停用")
+	}
+	codes = totp.NewRecoveryCodes(recoveryCodeCount)
+	tf = TwoFactor{
+		Secret:    totp.NewSecret(),
+		CreatedAt: model.TimeNow(),
+	}
+	for _, code := range codes {
+		tf.RecoveryCodes = append(tf.RecoveryCodes, totp.HashRecoveryCode(code))
+	}
+	err = db.DB.Set(metadataBucket, twoFactorKey, &tf)
+	return tf.Secret, codes, err
+}
+
+// ConfirmTwoFactor 用一个验证码确认密钥已被正确保存到手机 App, 然后正式启用两步验证。
+func (db *DB) ConfirmTwoFactor(code string) error {
+	tf, err := db.GetTwoFactor()
+	if err != nil {
+		return err
+	}
+	if tf.Secret == "" {
+		return errors.New("请先生成密钥")
+	}
+	if tf.Enabled {
+		return errors.New("两步验证已启用")
+	}
+	counter, ok := totp.Validate(tf.Secret, code, time.Now())
+	if !ok {
+		return errors.New("验证码错误")
+	}
+	tf.Enabled = true
+	tf.LastCounter = counter
+	return db.DB.Set(metadataBucket, twoFactorKey, &tf)
+}
+
+// DisableTwoFactor 停用两步验证并删除密钥与恢复码。
+func (db *DB) DisableTwoFactor() error {
+	return db.DB.Delete(metadataBucket, twoFactorKey)
+}
+
+// VerifyTwoFactor 验证 TOTP 验证码或恢复码，恢复码使用一次后即失效。
+func (db *DB) VerifyTwoFactor(code string) (bool, error) {
+	tf, ok, err := db.matchTwoFactor(code)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, db.DB.Set(metadataBucket, twoFactorKey, &tf)
+}
+
+// CheckTwoFactor 与 VerifyTwoFactor 相同，但不使验证码失效。
+// 登入时先用它检查，等其他检查 (例如 passphrase) 都通过后再调用 VerifyTwoFactor,
+// 以免因为其他错误白白用掉一个恢复码。
+func (db *DB) CheckTwoFactor(code string) (bool, error) {
+	_, ok, err := db.matchTwoFactor(code)
+	return ok, err
+}
+
+// matchTwoFactor 验证 code, 通过时返回使该 code 失效后的 tf (尚未保存)。
+func (db *DB) matchTwoFactor(code string) (tf TwoFactor, ok bool, err error) {
+	if tf, err = db.GetTwoFactor(); err != nil || !tf.Enabled {
+		return tf, false, err
+	}
+	counter, ok := totp.Validate(tf.Secret, code, time.Now())
+	if ok && counter > tf.LastCounter {
+		tf.LastCounter = counter
+		return tf, true, nil
+	}
+	i := util.StringIndex(tf.RecoveryCodes, totp.HashRecoveryCode(code))
+	if i < 0 {
+		return tf, false, nil
+	}
+	tf.RecoveryCodes = util.DeleteFromSlice(tf.RecoveryCodes, i)
+	return tf, true, nil
+}
@@ -0,0 +1,128 @@
+// Package encrypt 用于加密保存在数据库中的数据。
+// 密钥由 passphrase 通过 scrypt 推导而来，每一项数据都使用 AES-256-GCM 单独加密，
+// 并以该数据所属的行 (例如笔记 ID) 作为附加数据，防止密文被挪用到其他行。
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Prefix 加密后的字符串均以此为前缀，用来区分密文与明文。
+const Prefix = "enc1:"
+
+const (
+	keySize   = 32 // AES-256
+	saltSize  = 16
+	checkText = "uglynotes"
+)
+
+var (
+	ErrWrongPassphrase = errors.New("passphrase 错误")
+	ErrCiphertext      = errors.New("密文格式错误或已被篡改")
+)
+
+// Params 推导密钥所需的参数，可以保存到数据库（不含密钥本身）。
+type Params struct {
+	Salt  []byte
+	N     int
+	R     int
+	P     int
+	Check string // 用密钥加密 checkText 的结果，用来验证 passphrase 是否正确
+}
+
+// Key 由 passphrase 推导出来的密钥，只保存在内存中。
+type Key struct {
+	aead cipher.AEAD
+}
+
+// NewKey 使用新的随机 salt 生成密钥与对应的参数。
+func NewKey(passphrase string) (*Key, Params, error) {
+	params := Params{Salt: randomBytes(saltSize), N: 1 << 15, R: 8, P: 1}
+	key, err := deriveKey(passphrase, params)
+	if err != nil {
+		return nil, params, err
+	}
+	params.Check = key.Encrypt(checkText, "")
+	return key, params, nil
+}
+
+// OpenKey 根据已保存的参数推导密钥，并检查 passphrase 是否正确。
+func OpenKey(passphrase string, params Params) (*Key, error) {
+	key, err := deriveKey(passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+	check, err := key.Decrypt(params.Check, "")
+	if err != nil || subtle.ConstantTimeCompare([]byte(check), []byte(checkText)) != 1 {
+		return nil, ErrWrongPassphrase
+	}
+	return key, nil
+}
+
+func deriveKey(passphrase string, params Params) (*Key, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase is empty")
+	}
+	secret, err := scrypt.Key(
+		[]byte(passphrase), params.Salt, params.N, params.R, params.P, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{aead}, nil
+}
+
+// IsEncrypted reports whether s is a ciphertext produced by Key.Encrypt.
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, Prefix)
+}
+
+// Encrypt 加密 plaintext, 其中 ad (附加数据) 不会被加密，但解密时必须提供相同的 ad.
+func (key *Key) Encrypt(plaintext, ad string) string {
+	nonce := randomBytes(key.aead.NonceSize())
+	sealed := key.aead.Seal(nonce, nonce, []byte(plaintext), []byte(ad))
+	return Prefix + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// Decrypt 解密 Encrypt 的结果。
+func (key *Key) Decrypt(ciphertext, ad string) (string, error) {
+	if !IsEncrypted(ciphertext) {
+		return "", ErrCiphertext
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(ciphertext[len(Prefix):])
+	if err != nil {
+		return "", ErrCiphertext
+	}
+	n := key.aead.NonceSize()
+	if len(sealed) < n {
+		return "", ErrCiphertext
+	}
+	plaintext, err := key.aead.Open(nil, sealed[:n], sealed[n:], []byte(ad))
+	if err != nil {
+		return "", ErrCiphertext
+	}
+	return string(plaintext), nil
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,89 @@
+package encrypt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key, params, err := NewKey("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plaintext := range []string{"", "hello", "多行\n文字\n"} {
+		ciphertext := key.Encrypt(plaintext, "1ka")
+		if !IsEncrypted(ciphertext) || strings.Contains(ciphertext, "hello") {
+			t.Fatalf("Encrypt(%q) = %q", plaintext, ciphertext)
+		}
+		if again := key.Encrypt(plaintext, "1ka"); again == ciphertext {
+			t.Errorf("Encrypt(%q) should use a random nonce", plaintext)
+		}
+		got, err := key.Decrypt(ciphertext, "1ka")
+		if err != nil || got != plaintext {
+			t.Errorf("Decrypt = %q, %v, want %q", got, err, plaintext)
+		}
+	}
+
+	reopened, err := OpenKey("correct horse", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.Decrypt(key.Encrypt("hello", "1ka"), "1ka")
+	if err != nil || got != "hello" {
+		t.Errorf("reopened key: Decrypt = %q, %v", got, err)
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	key, _, err := NewKey("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := key.Encrypt("hello", "1ka")
+	tampered := ciphertext[:len(ciphertext)-2] + "AA"
+	if tampered == ciphertext {
+		tampered = ciphertext[:len(ciphertext)-2] + "BB"
+	}
+	tests := []struct {
+		name, ciphertext, ad string
+	}{
+		{"plaintext", "hello", "1ka"},
+		{"other row", ciphertext, "1kb"},
+		{"tampered", tampered, "1ka"},
+		{"bad base64", Prefix + "!!!", "1ka"},
+		{"too short", Prefix + "AAAA", "1ka"},
+	}
+	for _, tt := range tests {
+		if _, err := key.Decrypt(tt.ciphertext, tt.ad); err != ErrCiphertext {
+			t.Errorf("%s: err = %v, want ErrCiphertext", tt.name, err)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, oldParams, err := NewKey("old passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, newParams, err := NewKey("new passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := oldKey.Decrypt(oldKey.Encrypt("secret", "1ka"), "1ka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := newKey.Encrypt(plaintext, "1ka")
+	if _, err := oldKey.Decrypt(rotated, "1ka"); err != ErrCiphertext {
+		t.Errorf("old key should not decrypt rotated data, err = %v", err)
+	}
+	if _, err := OpenKey("old passphrase", newParams); err != ErrWrongPassphrase {
+		t.Errorf("OpenKey(old passphrase, new params) err = %v", err)
+	}
+	if _, err := OpenKey("new passphrase", oldParams); err != ErrWrongPassphrase {
+		t.Errorf("OpenKey(new passphrase, old params) err = %v", err)
+	}
+	if _, err := OpenKey("", newParams); err == nil {
+		t.Error("OpenKey with empty passphrase should fail")
+	}
+}
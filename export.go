@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ahui2016/uglynotes/archive"
+	"github.com/ahui2016/uglynotes/frontmatter"
+	"github.com/ahui2016/uglynotes/gitexport"
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+// fileNameLimit 限制导出文件名中标题部分的长度
+const fileNameLimit = 60
+
+var reUnsafeFileName = regexp.MustCompile(`[\\/:*?"<>|\x00-\x1f]+`)
+
+type archiveFile struct {
+	name    string
+	modTime time.Time
+	data    []byte
+}
+
+// noteFileName 返回 "id 标题.md" 形式的文件名，回收站中的笔记放在 trash 文件夹内。
+func noteFileName(note *Note) string {
+	ext := ".txt"
+	switch note.Type {
+	case model.Markdown:
+		ext = ".md"
+	case model.Encrypted:
+		ext = ".enc"
+	}
+	name := note.ID
+	title := strings.TrimSpace(reUnsafeFileName.ReplaceAllString(note.Title, " "))
+	if title != "" && !note.IsEncrypted() {
+		name += " " + headLimit(title, fileNameLimit)
+	}
+	if note.Deleted {
+		name = "trash/" + name
+	}
+	return name + ext
+}
+
+func noteMeta(note *Note) frontmatter.Meta {
+	return frontmatter.Meta{
+		ID:      note.ID,
+		Title:   note.Title,
+		Type:    string(note.Type),
+		Tags:    note.Tags,
+		Created: note.CreatedAt,
+		Updated: note.UpdatedAt,
+		Deleted: note.Deleted,
+	}
+}
+
+// noteFile 把笔记转换为带 front matter 的文件。
+// 客户端加密的笔记无法还原内容，因此原样导出全部 patches (每行一个)。
+func noteFile(note *Note) (file archiveFile, err error) {
+	var body string
+	if note.IsEncrypted() {
+		body = strings.Join(note.Patches, "\n") + "\n"
+	} else if body, err = note.CurrentContents(); err != nil {
+		return
+	}
+	modTime, err := model.ParseTime(note.UpdatedAt)
+	if err != nil {
+		modTime = time.Now()
+	}
+	return archiveFile{
+		name:    noteFileName(note),
+		modTime: modTime,
+		data:    []byte(frontmatter.Marshal(noteMeta(note), body)),
+	}, nil
+}
+
+// exportArchive 把全部笔记导出为 zip 或 tar.gz, 每篇笔记一个文件。
+// 参数: format ("zip" 或 "tar.gz", 默认 zip), trash ("true" 表示包括回收站中的笔记)。
+// 无法还原内容的笔记会被跳过，并记录在压缩包末尾的 errors.txt 中。
+func exportArchive(c *fiber.Ctx) error {
+	format := c.Query("format", "zip")
+	if _, ok := archive.Formats[format]; !ok {
+		return fiber.NewError(400, "unknown format: "+format)
+	}
+	withTrash := c.Query("trash") == "true"
+
+	notes, err := notesToExport(withTrash)
+	if err != nil {
+		return err
+	}
+	logAudit := deferredAudit(c, model.OpBackupArchive, nil)
+	sendArchive(c, "uglynotes", format, func(add addFile) error {
+		var count int
+		var failed []string
+		for i := range notes {
+			file, err := noteFile(&notes[i])
+			if err != nil {
+				failed = append(failed, notes[i].ID+": "+err.Error())
+				continue
+			}
+			if err := add(file); err != nil {
+				return err
+			}
+			count++
+		}
+		if err := addErrorsFile(add, "errors.txt", failed); err != nil {
+			return err
+		}
+		logAudit("", fmt.Sprintf("format=%s trash=%t files=%d failed=%d",
+			format, withTrash, count, len(failed)))
+		return nil
+	})
+	return nil
+}
+
+// exportGit 把全部笔记的历史版本导出为 git 仓库 (详见 gitexport.Export),
+// 打包为 zip 或 tar.gz (解压后得到 uglynotes 文件夹)。参数与 exportArchive 相同。
+func exportGit(c *fiber.Ctx) error {
+	format := c.Query("format", "zip")
+	if _, ok := archive.Formats[format]; !ok {
+		return fiber.NewError(400, "unknown format: "+format)
+	}
+	withTrash := c.Query("trash") == "true"
+
+	notes, err := notesToExport(withTrash)
+	if err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir("", "uglynotes-git-")
+	if err != nil {
+		return err
+	}
+	failed, err := gitexport.Export(dir, notes)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	// 临时文件夹在发送完毕后才删除。
+	sendArchive(c, "uglynotes-git", format, func(add addFile) error {
+		defer os.RemoveAll(dir)
+		if err := addDirFiles(add, dir, "uglynotes/"); err != nil {
+			return err
+		}
+		return addErrorsFile(add, "uglynotes-errors.txt", failed)
+	})
+	audit(c, model.OpBackupGit, nil, "", fmt.Sprintf(
+		"format=%s trash=%t notes=%d failed=%d",
+		format, withTrash, len(notes), len(failed)))
+	return nil
+}
+
+// notesToExport 返回全部笔记 (已解密)，withTrash 为 false 时不包括回收站中的笔记。
+func notesToExport(withTrash bool) (notes []Note, err error) {
+	all, err := db.AllNotesWithDeleted()
+	if err != nil {
+		return nil, err
+	}
+	for _, note := range all {
+		if note.Deleted && !withTrash {
+			continue
+		}
+		notes = append(notes, note)
+	}
+	return notes, nil
+}
+
+// addFile 把一个文件写进正在发送的压缩包。
+type addFile func(file archiveFile) error
+
+func addErrorsFile(add addFile, name string, failed []string) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return add(archiveFile{
+		name:    name,
+		modTime: time.Now(),
+		data:    []byte(strings.Join(failed, "\n") + "\n"),
+	})
+}
+
+// addDirFiles 把文件夹 dir 内的全部文件逐个写进压缩包，文件名加上前缀 prefix.
+func addDirFiles(add addFile, dir, prefix string) error {
+	return filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		return add(archiveFile{
+			name:    prefix + filepath.ToSlash(rel),
+			modTime: info.ModTime(),
+			data:    data,
+		})
+	})
+}
+
+// sendArchive 以流的形式发送 format 格式的压缩包 (作为附件)。
+// write 在 handler 返回后才被调用 (因此不能使用 fiber.Ctx)，它每生成一个文件就用 add
+// 写进压缩包，因此整个压缩包不需要放在内存中。
+func sendArchive(c *fiber.Ctx, name, format string, write func(add addFile) error) {
+	filename := name + "-" + time.Now().Format("20060102") + "." + format
+	c.Set(fiber.HeaderContentType, archive.Formats[format])
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := writeArchive(w, format, write); err != nil {
+			log.Printf("export %s: %v", name, err)
+		}
+	})
+}
+
+func writeArchive(w *bufio.Writer, format string, write func(add addFile) error) error {
+	aw, err := archive.NewWriter(w, format)
+	if err != nil {
+		return err
+	}
+	err = write(func(file archiveFile) error {
+		return aw.Add(file.name, file.modTime, file.data)
+	})
+	if err != nil {
+		return err
+	}
+	if err := aw.Close(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
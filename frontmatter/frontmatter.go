@@ -0,0 +1,184 @@
+// Package frontmatter 读写 Markdown 文件开头的 YAML front matter (只支持笔记所需的简单格式)。
+package frontmatter
+
+import (
+	"strconv"
+	"strings"
+)
+
+const delimiter = "---"
+
+// Meta 笔记的元数据。
+type Meta struct {
+	ID      string
+	Title   string
+	Type    string
+	Tags    []string
+	Created string
+	Updated string
+	Deleted bool
+}
+
+// Marshal 把 meta 转换为 front matter, 并在后面接上 body.
+func Marshal(meta Meta, body string) string {
+	var b strings.Builder
+	b.WriteString(delimiter + "\n")
+	writeString(&b, "id", meta.ID)
+	writeString(&b, "title", meta.Title)
+	writeString(&b, "type", meta.Type)
+	b.WriteString("tags: [")
+	for i, tag := range meta.Tags {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(quote(tag))
+	}
+	b.WriteString("]\n")
+	writeString(&b, "created", meta.Created)
+	writeString(&b, "updated", meta.Updated)
+	b.WriteString("deleted: " + strconv.FormatBool(meta.Deleted) + "\n")
+	b.WriteString(delimiter + "\n\n")
+	b.WriteString(body)
+	return b.String()
+}
+
+func writeString(b *strings.Builder, key, value string) {
+	if value == "" {
+		return
+	}
+	b.WriteString(key + ": " + quote(value) + "\n")
+}
+
+// Parse 读取 data 开头的 front matter, 返回元数据与正文。
+// 如果 data 不以 "---" 开头，或找不到结束的 "---", 则 found 为 false, body 为 data 本身。
+// 只支持简单的 "key: value" 格式，列表可写为 [a, b] 或多行 "- a"; 未知的 key 会被忽略。
+// 为了兼容其他软件，date 视为 created, modified/lastmod 视为 updated, tag 视为 tags,
+// tags 也可以是逗号或空格分隔的字符串。
+func Parse(data string) (meta Meta, body string, found bool) {
+	data = strings.TrimPrefix(data, "\ufeff")
+	lines := strings.SplitAfter(data, "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != delimiter {
+		return meta, data, false
+	}
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == delimiter || line == "..." {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return meta, data, false
+	}
+
+	var lastKey string
+	for _, line := range lines[1:end] {
+		line = strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") && isTagsKey(lastKey) {
+			meta.Tags = append(meta.Tags, unquote(trimmed[2:]))
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		lastKey = strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+		switch lastKey {
+		case "id":
+			meta.ID = unquote(value)
+		case "title":
+			meta.Title = unquote(value)
+		case "type":
+			meta.Type = unquote(value)
+		case "tags", "tag":
+			meta.Tags = append(meta.Tags, parseList(value)...)
+		case "created", "date":
+			meta.Created = unquote(value)
+		case "updated", "modified", "lastmod":
+			meta.Updated = unquote(value)
+		case "deleted":
+			meta.Deleted, _ = strconv.ParseBool(unquote(value))
+		}
+	}
+	body = strings.Join(lines[end+1:], "")
+	body = strings.TrimPrefix(strings.TrimPrefix(body, "\r"), "\n")
+	return meta, body, true
+}
+
+func isTagsKey(key string) bool {
+	return key == "tags" || key == "tag"
+}
+
+// parseList 解析 [a, "b"] 或 "a, b" 或 "a b" 形式的列表。
+func parseList(value string) (list []string) {
+	if value == "" {
+		return nil
+	}
+	var items []string
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		items = splitQuoted(value[1 : len(value)-1])
+	} else if strings.Contains(value, ",") {
+		items = splitQuoted(value)
+	} else {
+		items = strings.Fields(value)
+	}
+	for _, item := range items {
+		if item = unquote(strings.TrimSpace(item)); item != "" {
+			list = append(list, item)
+		}
+	}
+	return
+}
+
+// splitQuoted 以逗号分割 s, 但忽略引号内的逗号。
+func splitQuoted(s string) (items []string) {
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++ // 跳过被转义的字符
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
+
+// unquote 去除 YAML 字符串两边的引号（双引号字符串按转义规则解析）。
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return s
+	}
+	switch {
+	case s[0] == '"' && s[len(s)-1] == '"':
+		if unquoted, err := strconv.Unquote(s); err == nil {
+			return unquoted
+		}
+		return s[1 : len(s)-1]
+	case s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	return s
+}
+
+// quote 返回 YAML 双引号字符串。Go 的转义格式 (\n, \t, \", \\, \x.., \u....)
+// 都是 YAML 双引号字符串的合法转义。
+func quote(s string) string {
+	return strconv.Quote(s)
+}
@@ -0,0 +1,129 @@
+// Package gitexport 把全部笔记的历史版本导出为一个 git 仓库 (使用 go-git, 不需要 git 命令)。
+package gitexport
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Author 是每个提交的作者与提交者。
+var Author = object.Signature{Name: "uglynotes", Email: "uglynotes@localhost"}
+
+// version 是一篇笔记的一个历史版本，对应一个提交。
+type version struct {
+	when     time.Time
+	name     string // 文件名
+	message  string
+	contents string
+}
+
+// FileName 返回笔记在仓库中的文件名 (ID 加扩展名，标题改变时文件名不变)，
+// 回收站中的笔记放在 trash 文件夹内。
+func FileName(note *model.Note) string {
+	name := note.ID + ".txt"
+	if note.Type == model.Markdown {
+		name = note.ID + ".md"
+	}
+	if note.Deleted {
+		name = path.Join("trash", name)
+	}
+	return name
+}
+
+// Export 在文件夹 dir (必须不存在或为空) 中新建一个 git 仓库，每篇笔记一个文件，
+// 每个 patch 一个提交，提交时间是该 patch 的创建时间，提交信息包含该版本的标题，
+// 因此可以用 git log -p 查看每一个版本。
+// 客户端加密的笔记无法还原，会被跳过；无法还原的版本及其后的版本也会被跳过。
+// 返回被跳过的笔记及原因。
+func Export(dir string, notes []model.Note) (failed []string, err error) {
+	var versions []version
+	for i := range notes {
+		noteVersions, err := noteVersions(&notes[i])
+		if err != nil {
+			failed = append(failed, notes[i].ID+": "+err.Error())
+		}
+		versions = append(versions, noteVersions...)
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].when.Before(versions[j].when)
+	})
+	return failed, commitAll(dir, versions)
+}
+
+func noteVersions(note *model.Note) (versions []version, err error) {
+	if note.IsEncrypted() {
+		return nil, fmt.Errorf("cannot export an encrypted note")
+	}
+	var contents string
+	var last time.Time
+	for i, patch := range note.Patches {
+		if contents, err = model.PatchApply(patch, contents); err != nil {
+			return versions, fmt.Errorf("version %d: %w", i+1, err)
+		}
+		when, err := model.ParseTime(note.PatchTime(i))
+		if err != nil || when.Before(last) {
+			when = last // 确保同一篇笔记的版本按顺序提交
+		}
+		last = when
+		versions = append(versions, version{
+			when:     when,
+			name:     FileName(note),
+			message:  fmt.Sprintf("%s v%d: %s", note.ID, i+1, versionTitle(note, contents)),
+			contents: contents,
+		})
+	}
+	return versions, nil
+}
+
+// versionTitle 按照 Note.SetTitle 的规则，返回某个版本的标题。
+func versionTitle(note *model.Note, contents string) string {
+	contents = strings.TrimSpace(contents)
+	if contents == "" {
+		return ""
+	}
+	tmp := model.Note{Type: note.Type}
+	tmp.SetTitle(contents)
+	return tmp.Title
+}
+
+func commitAll(dir string, versions []version) error {
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		filename := filepath.Join(dir, filepath.FromSlash(v.name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filename, []byte(v.contents), 0600); err != nil {
+			return err
+		}
+		if _, err := worktree.Add(v.name); err != nil {
+			return err
+		}
+		signature := Author
+		signature.When = v.when
+		if _, err := worktree.Commit(v.message, &git.CommitOptions{
+			Author:    &signature,
+			Committer: &signature,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,8 +4,10 @@ go 1.14
 
 require (
 	github.com/asdine/storm/v3 v3.2.1
+	github.com/go-git/go-git/v5 v5.2.0
 	github.com/gofiber/fiber/v2 v2.3.0
 	github.com/ianbruene/go-difflib v1.2.0
 	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/sergi/go-diff v1.1.0
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 )
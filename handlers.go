@@ -2,9 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"unicode/utf8"
 
+	"github.com/ahui2016/uglynotes/encrypt"
 	"github.com/ahui2016/uglynotes/model"
 	"github.com/ahui2016/uglynotes/util"
 	"github.com/gofiber/fiber/v2"
@@ -39,6 +41,9 @@ func indexPage(c *fiber.Ctx) error {
 }
 
 func loginPage(c *fiber.Ctx) error {
+	if c.Cookies(csrfCookie) == "" {
+		setCSRFCookie(c, newCSRFToken())
+	}
 	return c.SendFile("./public/login.html")
 }
 
@@ -110,44 +115,121 @@ func loginHandler(c *fiber.Ctx) error {
 		}
 		return jsonError(c, "Wrong Password", 400)
 	}
+	// 先检查验证码，等 passphrase 也正确后才使验证码失效。
+	code, err := checkTwoFactor(c)
+	if err != nil {
+		return err
+	}
+	if err := unlockData(c); err != nil {
+		return err
+	}
+	twoFactor := code != ""
+	if twoFactor {
+		if err := consumeTwoFactor(code); err != nil {
+			return err
+		}
+	}
 	if err := db.Upgrade(); err != nil {
 		return err
 	}
 	passwordTry = 0
-	return db.SessionSet(c)
+	csrfToken := newCSRFToken()
+	if err := db.SessionSet(c, twoFactor, csrfToken); err != nil {
+		return err
+	}
+	setCSRFCookie(c, csrfToken)
+	audit(c, model.OpLogin, nil, "", fmt.Sprintf("2fa=%t", twoFactor))
+	return nil
 }
 
 func checkLogin(c *fiber.Ctx) error {
 	if isLoggedIn(c) {
 		return jsonMessage(c, "OK")
 	}
-	return jsonMessage(c, "NG")
+	return c.JSON(fiber.Map{
+		"message":    "NG",
+		"passphrase": db.DataLocked(),
+	})
+}
+
+// unlockData 在启用加密且尚未解锁时，要求输入 passphrase.
+func unlockData(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	if !db.DataLocked() {
+		return nil
+	}
+	passphrase := c.FormValue("passphrase")
+	if passphrase == "" {
+		return fiber.NewError(400, "Require Passphrase")
+	}
+	if err := db.UnlockData(passphrase); err != nil {
+		if err == encrypt.ErrWrongPassphrase {
+			passwordTry++
+			if err := checkPasswordTry(c); err != nil {
+				return err
+			}
+		}
+		return fiber.NewError(400, err.Error())
+	}
+	return nil
 }
 
 func getAllNotes(c *fiber.Ctx) error {
+	at, ok, err := getAt(c)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return getNotesAt(c, at, false)
+	}
 	notes, err := db2.AllNotes()
 	if err != nil {
 		return err
 	}
+	if err := db.DecryptTitles(notes); err != nil {
+		return err
+	}
 	// trimContents(notes)
 	return c.JSON(notes)
 }
 
 func getDeletedNotes(c *fiber.Ctx) error {
+	at, ok, err := getAt(c)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return getNotesAt(c, at, true)
+	}
 	notes, err := db2.AllDeletedNotes()
 	if err != nil {
 		return err
 	}
+	if err := db.DecryptTitles(notes); err != nil {
+		return err
+	}
 	// trimContents(notes)
 	return c.JSON(notes)
 }
 
+// exportAllNotes 导出全部笔记，启用加密时导出的是密文。
 func exportAllNotes(c *fiber.Ctx) error {
-	notes, err := db.AllNotesWithDeleted()
+	notes, err := db.RawNotesWithDeleted()
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(exportPath, util.MustMarshalIndent(notes), 0600)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(exportPath, util.MustMarshalIndent(notes), 0600)
+	audit(c, model.OpBackupExport, nil, "", fmt.Sprintf("notes=%d", len(notes)))
+	return nil
+}
+
+func encryptionScheme(c *fiber.Ctx) error {
+	return c.JSON(model.ClientEncryption)
 }
 
 func trimContents(notes []Note) {
@@ -157,6 +239,13 @@ func trimContents(notes []Note) {
 }
 
 func getNoteHandler(c *fiber.Ctx) error {
+	at, ok, err := getAt(c)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return getNoteAt(c, at)
+	}
 	note, err := db.GetByID(c.Params("id"))
 	if err != nil {
 		return err
@@ -175,6 +264,7 @@ func newNoteHandler(c *fiber.Ctx) error {
 	if err := db.Insert(note); err != nil {
 		return err
 	}
+	auditNote(c, model.OpNoteCreate, note.ID, nil)
 	return jsonMessage(c, note.ID)
 }
 
@@ -192,6 +282,7 @@ func createNote(c *fiber.Ctx) (*Note, error) {
 	if err := util.WrapErrors(err1, err2); err != nil {
 		return nil, err
 	}
+	note.SetVersionSession(db.SessionLabel(c))
 	return note, nil
 }
 
@@ -204,7 +295,15 @@ func changeType(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
-	return db.ChangeType(id, noteType)
+	before, err := db.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := db.ChangeType(id, noteType); err != nil {
+		return err
+	}
+	auditNote(c, model.OpNoteType, id, &before)
+	return nil
 }
 
 func updateNoteTags(c *fiber.Ctx) error {
@@ -216,7 +315,15 @@ func updateNoteTags(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
-	return db.UpdateTags(id, tags)
+	before, err := db.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := db.UpdateTags(id, tags); err != nil {
+		return err
+	}
+	auditNote(c, model.OpNoteTags, id, &before)
+	return nil
 }
 
 func patchNoteHandler(c *fiber.Ctx) error {
@@ -230,11 +337,17 @@ func patchNoteHandler(c *fiber.Ctx) error {
 		return err
 	}
 
-	count, err := db.AddPatchSetTitle(id, patch, title)
+	before, err := db.GetByID(id)
 	if err != nil {
 		return err
 	}
-	return c.JSON(fiber.Map{"message": count})
+	count, renamed, err := db.AddPatchSetTitle(id, patch, title, db.SessionLabel(c))
+	if err != nil {
+		return err
+	}
+	auditNote(c, model.OpNotePatch, id, &before)
+	auditRenamedLinks(c, id, renamed)
+	return c.JSON(fiber.Map{"message": count, "skipped": renamed.Skipped})
 }
 
 func notesSizeHandler(c *fiber.Ctx) error {
@@ -257,7 +370,12 @@ func setTagGroupProtected(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
-	return db.SetTagGroupProtected(groupID, protected)
+	if err := db.SetTagGroupProtected(groupID, protected); err != nil {
+		return err
+	}
+	audit(c, model.OpTagGroupProtect, []string{groupID},
+		"", fmt.Sprintf("protected=%t", protected))
+	return nil
 }
 
 func shortHistories(histories []History) {
@@ -296,7 +414,34 @@ func renameTag(c *fiber.Ctx) error {
 	if err := util.WrapErrors(err1, err2); err != nil {
 		return err
 	}
-	return db.RenameTag(oldName, newName)
+	if err := db.RenameTag(oldName, newName); err != nil {
+		return err
+	}
+	audit(c, model.OpTagRename, []string{oldName, newName}, oldName, newName)
+	return nil
+}
+
+// mergeTags 把标签 source 合并到 target, 详见 DB.MergeTags.
+func mergeTags(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	source, err1 := getFormValue(c, "source")
+	target, err2 := getFormValue(c, "target")
+	if err := util.WrapErrors(err1, err2); err != nil {
+		return err
+	}
+	src, err := db.GetTag(source)
+	if err != nil {
+		return fmt.Errorf("tag[%s] %w", source, err)
+	}
+	merged, err := db.MergeTags(source, target)
+	if err != nil {
+		return err
+	}
+	audit(c, model.OpTagMerge, append([]string{source, merged.Name}, src.NoteIDs...),
+		fmt.Sprintf("notes=%d", len(src.NoteIDs)), fmt.Sprintf("notes=%d", len(merged.NoteIDs)))
+	return c.JSON(merged)
 }
 
 func getNotesByTag(c *fiber.Ctx) error {
@@ -304,6 +449,13 @@ func getNotesByTag(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
+	at, ok, err := getAt(c)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return getNotesByTagAt(c, tagName, at)
+	}
 	notes, err := db.GetByTag(tagName)
 	if err != nil {
 		return err
@@ -312,6 +464,13 @@ func getNotesByTag(c *fiber.Ctx) error {
 }
 
 func getAllTags(c *fiber.Ctx) error {
+	at, ok, err := getAt(c)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return getTagsAt(c, at, false)
+	}
 	tags, err := db.AllTags()
 	if err != nil {
 		return err
@@ -320,6 +479,13 @@ func getAllTags(c *fiber.Ctx) error {
 }
 
 func allTagsByDate(c *fiber.Ctx) error {
+	at, ok, err := getAt(c)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return getTagsAt(c, at, true)
+	}
 	tags, err := db.AllTagsByDate()
 	if err != nil {
 		return err
@@ -335,6 +501,27 @@ func allTagGroups(c *fiber.Ctx) error {
 	return c.JSON(groups)
 }
 
+// getTagTree 返回标签树，每个节点包括直接使用该标签的笔记数量，
+// 以及使用该标签或其子标签的笔记数量。
+func getTagTree(c *fiber.Ctx) error {
+	at, ok, err := getAt(c)
+	if err != nil {
+		return err
+	}
+	if ok {
+		tags, err := db.TagsAt(at)
+		if err != nil {
+			return err
+		}
+		return c.JSON(model.TagTree(tags))
+	}
+	tree, err := db.TagTree()
+	if err != nil {
+		return err
+	}
+	return c.JSON(tree)
+}
+
 // TODO: 如果只有一个标签，则不使用 db.SearchTagGroup
 func searchTagGroup(c *fiber.Ctx) error {
 	tags, err := getTagGroup(c)
@@ -374,6 +561,7 @@ func addTagGroup(c *fiber.Ctx) error {
 	if err := db.SaveTagGroup(group); err != nil {
 		return err
 	}
+	audit(c, model.OpTagGroupAdd, []string{group.ID}, "", fmt.Sprint(group.Tags))
 	return c.JSON(group)
 }
 
@@ -382,7 +570,15 @@ func deleteTagGroup(c *fiber.Ctx) error {
 	defer db.Unlock()
 
 	groupID := c.Params("id")
-	return db.DB.DeleteStruct(&TagGroup{ID: groupID})
+	group, err := db.GetTagGroup(groupID)
+	if err != nil {
+		return err
+	}
+	if err := db.DB.DeleteStruct(&group); err != nil {
+		return err
+	}
+	audit(c, model.OpTagGroupDelete, []string{groupID}, fmt.Sprint(group.Tags), "")
+	return nil
 }
 
 func setNoteDeleted(c *fiber.Ctx) error {
@@ -394,7 +590,15 @@ func setNoteDeleted(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
-	return db.SetNoteDeleted(id, deleted)
+	before, err := db.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := db.SetNoteDeleted(id, deleted); err != nil {
+		return err
+	}
+	auditNote(c, model.OpNoteDeleted, id, &before)
+	return nil
 }
 
 func deleteNoteForever(c *fiber.Ctx) error {
@@ -402,7 +606,15 @@ func deleteNoteForever(c *fiber.Ctx) error {
 	defer db.Unlock()
 
 	id := c.Params("id")
-	return db.DeleteNoteForever(id)
+	before, err := db.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := db.DeleteNoteForever(id); err != nil {
+		return err
+	}
+	auditNote(c, model.OpNoteDelete, id, &before)
+	return nil
 }
 
 func deleteTag(c *fiber.Ctx) error {
@@ -413,15 +625,71 @@ func deleteTag(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
-	return db.DeleteTag(name)
+	tag, err := db.GetTag(name)
+	if err != nil {
+		return err
+	}
+	if err := db.DeleteTag(name); err != nil {
+		return err
+	}
+	audit(c, model.OpTagDelete, append([]string{name}, tag.NoteIDs...),
+		fmt.Sprintf("notes=%d", len(tag.NoteIDs)), "")
+	return nil
 }
 
-func deleteNoteHistories(c *fiber.Ctx) error {
+func getTagAliases(c *fiber.Ctx) error {
+	aliases, err := db.AllTagAliases()
+	if err != nil {
+		return err
+	}
+	if aliases == nil {
+		aliases = []model.TagAlias{}
+	}
+	return c.JSON(aliases)
+}
+
+func addTagAlias(c *fiber.Ctx) error {
 	db.Lock()
 	defer db.Unlock()
 
-	id := c.Params("id")
-	return db.DeleteNoteHistory(id)
+	alias, err1 := getFormValue(c, "alias")
+	tag, err2 := getFormValue(c, "tag")
+	if err := util.WrapErrors(err1, err2); err != nil {
+		return err
+	}
+	tagAlias, err := db.AddTagAlias(alias, tag)
+	if err != nil {
+		return err
+	}
+	audit(c, model.OpTagAliasAdd, []string{tagAlias.Alias, tagAlias.Tag}, "", tagAlias.Tag)
+	return c.JSON(tagAlias)
+}
+
+func deleteTagAlias(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	name, err := getParams(c, "alias")
+	if err != nil {
+		return err
+	}
+	alias, err := db.DeleteTagAlias(name)
+	if err != nil {
+		return err
+	}
+	audit(c, model.OpTagAliasDelete, []string{alias.Alias, alias.Tag}, alias.Tag, "")
+	return nil
+}
+
+func resetAllTags(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	if err := db.ResetAllTags(); err != nil {
+		return err
+	}
+	audit(c, model.OpBackupResetTags, nil, "", "")
+	return nil
 }
 
 func importNotes(c *fiber.Ctx) error {
@@ -433,5 +701,13 @@ func importNotes(c *fiber.Ctx) error {
 	if err = json.Unmarshal(blob, &notes); err != nil {
 		return err
 	}
-	return db2.ImportNotes(notes)
+	// db2 是另一个文件，启用加密时也必须保存密文 (读取时再解密)。
+	if err := db.EncryptNotes(notes); err != nil {
+		return err
+	}
+	if err := db2.ImportNotes(notes); err != nil {
+		return err
+	}
+	audit(c, model.OpBackupImport, nil, "", fmt.Sprintf("notes=%d", len(notes)))
+	return nil
 }
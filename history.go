@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/ahui2016/uglynotes/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+// VersionInfo 一个历史版本的元数据，Version 从 1 开始。
+type VersionInfo struct {
+	Version   int
+	CreatedAt string // 升级前的旧版本没有记录时间，此时为空字符串
+	Size      int
+	Session   string
+}
+
+// NoteDiff 两个版本之间的差异。
+type NoteDiff struct {
+	From    int
+	To      int
+	Unified string           // unified diff, 与前端 jsdiff 的 createPatch 格式相同
+	Words   []model.WordDiff // 单词级 diff
+	Hunks   []model.DiffHunk // 结构化 diff
+}
+
+// CompactResult 压缩历史的结果。
+type CompactResult struct {
+	Removed   int // 被删除的版本数量
+	Versions  int // 压缩后的版本数量
+	Size      int // 压缩后的笔记体积
+	Reclaimed int // 节省的体积
+}
+
+// getNoteHistory 返回一篇笔记的全部历史版本的元数据（按版本顺序）。
+func getNoteHistory(c *fiber.Ctx) error {
+	note, err := db.GetByID(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	history := note.History()
+	versions := make([]VersionInfo, len(history))
+	for i, v := range history {
+		versions[i] = VersionInfo{
+			Version:   i + 1,
+			CreatedAt: v.CreatedAt,
+			Size:      v.Size,
+			Session:   v.Session,
+		}
+	}
+	return c.JSON(versions)
+}
+
+// getNoteBlame 返回当前内容的每一行，以及最后修改该行的版本与时间。
+func getNoteBlame(c *fiber.Ctx) error {
+	note, err := db.GetByID(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	if note.IsEncrypted() {
+		return fiber.NewError(400, "cannot blame an encrypted note on the server")
+	}
+	blame, err := note.Blame()
+	if err != nil {
+		return err
+	}
+	if blame == nil {
+		blame = []model.BlameLine{}
+	}
+	return c.JSON(blame)
+}
+
+// getVersionParam 获取 query 中的版本号 (0 表示空内容)，为空时返回 defaultValue.
+func getVersionParam(c *fiber.Ctx, key string, defaultValue int, note *Note) (int, error) {
+	value := strings.TrimSpace(c.Query(key))
+	if value == "" {
+		return defaultValue, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 0 || version > len(note.Patches) {
+		return 0, fiber.NewError(400, fmt.Sprintf(
+			"%s: version out of range [0, %d]", key, len(note.Patches)))
+	}
+	return version, nil
+}
+
+// getNoteDiff 返回两个版本之间的差异 (query: from, to)。
+// to 默认为最新版本，from 默认为 to 的上一个版本。
+// 如果 format=unified, 则只返回 unified diff (纯文本)。
+func getNoteDiff(c *fiber.Ctx) error {
+	note, err := db.GetByID(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	if note.IsEncrypted() {
+		return fiber.NewError(400, "cannot diff an encrypted note")
+	}
+	to, err := getVersionParam(c, "to", len(note.Patches), &note)
+	if err != nil {
+		return err
+	}
+	defaultFrom := to - 1
+	if defaultFrom < 0 {
+		defaultFrom = 0
+	}
+	from, err := getVersionParam(c, "from", defaultFrom, &note)
+	if err != nil {
+		return err
+	}
+
+	a, err := note.ContentsAt(from)
+	if err != nil {
+		return err
+	}
+	b, err := note.ContentsAt(to)
+	if err != nil {
+		return err
+	}
+	unified := model.MakePatch(a, b)
+	if c.Query("format") == "unified" {
+		return c.SendString(unified)
+	}
+	hunks, err := model.LineDiff(a, b)
+	if err != nil {
+		return err
+	}
+	return c.JSON(NoteDiff{
+		From:    from,
+		To:      to,
+		Unified: unified,
+		Words:   model.WordDiffs(a, b),
+		Hunks:   hunks,
+	})
+}
+
+// restoreNote 把笔记恢复为第 version 个版本 (表单或 query)：添加一个新的 patch,
+// 把当前的内容改为该版本的内容，不会修改或删除任何历史版本。
+func restoreNote(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	id := c.Params("id")
+	before, err := db.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if before.IsEncrypted() {
+		return fiber.NewError(400, "cannot restore an encrypted note on the server")
+	}
+	version, err := getVersion(c)
+	if err != nil || version < 1 || version > len(before.Patches) {
+		return fiber.NewError(400, fmt.Sprintf(
+			"version out of range [1, %d]", len(before.Patches)))
+	}
+	target, err := before.ContentsAt(version)
+	if err != nil {
+		return err
+	}
+	current, err := before.CurrentContents()
+	if err != nil {
+		return err
+	}
+	if target == current {
+		return fiber.NewError(400, "the contents are the same as the current version")
+	}
+	if strings.TrimSpace(target) == "" {
+		return fiber.NewError(400, "cannot restore an empty version")
+	}
+
+	patch := model.MakePatch(current, target)
+	count, renamed, err := db.AddPatchSetTitle(id, patch, strings.TrimSpace(target), db.SessionLabel(c))
+	if err != nil {
+		return err
+	}
+	auditNote(c, model.OpNoteRestore, id, &before)
+	auditRenamedLinks(c, id, renamed)
+	return c.JSON(fiber.Map{"message": count, "skipped": renamed.Skipped})
+}
+
+// getMilestones 返回一篇笔记的全部里程碑（按版本顺序）。
+func getMilestones(c *fiber.Ctx) error {
+	note, err := db.GetByID(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	milestones := note.Milestones
+	if milestones == nil {
+		milestones = []model.Milestone{}
+	}
+	return c.JSON(milestones)
+}
+
+// addMilestone 给指定的版本加上里程碑，如果该版本已有里程碑则替换之。
+// 表单中的 protected 可省略（默认为 false）。
+func addMilestone(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	id := c.Params("id")
+	version, err := getVersion(c)
+	if err != nil {
+		return err
+	}
+	protected := false
+	if c.FormValue("protected") != "" {
+		if protected, err = getProtected(c); err != nil {
+			return err
+		}
+	}
+	label := strings.TrimSpace(c.FormValue("label"))
+	milestone := model.NewMilestone(version, label, protected)
+	if err := db.SetMilestone(id, milestone); err != nil {
+		return fiber.NewError(400, err.Error())
+	}
+	audit(c, model.OpMilestoneAdd, []string{id}, "",
+		fmt.Sprintf("version=%d protected=%t", version, protected))
+	return c.JSON(milestone)
+}
+
+func deleteMilestone(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	id := c.Params("id")
+	version, err := strconv.Atoi(c.Params("version"))
+	if err != nil {
+		return err
+	}
+	if err := db.DeleteMilestone(id, version); err != nil {
+		return err
+	}
+	audit(c, model.OpMilestoneDelete, []string{id},
+		fmt.Sprintf("version=%d", version), "")
+	return nil
+}
+
+// formOrQuery 获取表单或 query 中的值。
+func formOrQuery(c *fiber.Ctx, key string) string {
+	if value := strings.TrimSpace(c.FormValue(key)); value != "" {
+		return value
+	}
+	return strings.TrimSpace(c.Query(key))
+}
+
+// getCompactBefore 获取表单或 query 中的 before (版本号) 或 date (日期),
+// 两者都为空时返回最新版本的版本号（即合并最新版本之前的全部版本）。
+func getCompactBefore(c *fiber.Ctx, note *Note) (int, error) {
+	if before := formOrQuery(c, "before"); before != "" {
+		return strconv.Atoi(before)
+	}
+	date := formOrQuery(c, "date")
+	if date == "" {
+		return len(note.Patches), nil
+	}
+	t, err := parseTimeParam(date)
+	if err != nil {
+		return 0, err
+	}
+	return note.FirstVersionSince(t), nil
+}
+
+// compactHistory 压缩历史版本以节省空间，详见 DB.CompactHistory.
+func compactHistory(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	id := c.Params("id")
+	before, err := db.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if before.IsEncrypted() {
+		return fiber.NewError(400, "cannot compact an encrypted note on the server")
+	}
+	version, err := getCompactBefore(c, &before)
+	if err != nil {
+		return err
+	}
+	removed, err := db.CompactHistory(id, version)
+	if err != nil {
+		return err
+	}
+	note, err := db.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		auditNote(c, model.OpNoteCompact, id, &before)
+	}
+	return c.JSON(CompactResult{
+		Removed:   removed,
+		Versions:  len(note.Patches),
+		Size:      note.Size,
+		Reclaimed: before.Size - note.Size,
+	})
+}
+
+// forkNote 以第 version 个版本 (默认为最新版本) 的内容创建一篇新笔记，
+// 标签默认与原笔记相同，也可以在表单中指定新的标签。
+func forkNote(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	id := c.Params("id")
+	source, err := db.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if source.IsEncrypted() {
+		return fiber.NewError(400, "cannot fork an encrypted note on the server")
+	}
+	version, err := getVersion(c)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		version = len(source.Patches)
+	}
+	if version < 1 || version > len(source.Patches) {
+		return fiber.NewError(400, fmt.Sprintf(
+			"version out of range [1, %d]", len(source.Patches)))
+	}
+	contents, err := source.ContentsAt(version)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(contents) == "" {
+		return fiber.NewError(400, "cannot fork an empty version")
+	}
+	tags := source.Tags
+	if c.FormValue("tags") != "" {
+		if tags, err = getTags(c); err != nil {
+			return fiber.NewError(400, err.Error())
+		}
+	}
+
+	note := db.NewNote(source.Type)
+	note.ForkOf, note.ForkVersion = id, version
+	err1 := note.SetTags(tags)
+	err2 := note.AddPatchSetTitle(model.MakePatch("", contents), strings.TrimSpace(contents))
+	if err := util.WrapErrors(err1, err2); err != nil {
+		return fiber.NewError(400, err.Error())
+	}
+	note.SetVersionSession(db.SessionLabel(c))
+	if err := db.InsertFork(note); err != nil {
+		return err
+	}
+	audit(c, model.OpNoteFork, []string{note.ID, id}, "",
+		fmt.Sprintf("%s forkOf=%s version=%d", noteSummary(note), id, version))
+	return jsonMessage(c, note.ID)
+}
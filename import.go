@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"strings"
+	"time"
+
+	"github.com/ahui2016/uglynotes/importer"
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/ahui2016/uglynotes/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+// importers 各种格式的转换函数，key 是 format 参数。
+var importers = map[string]func([]importer.File) ([]importer.Draft, []importer.Issue){
+	"markdown": importer.Markdown,
+	"enex":     importer.ENEX,
+	"obsidian": importer.Obsidian,
+	"joplin":   importer.Joplin,
+}
+
+// ImportResult 导入结果，Imported 是新笔记的 ID.
+// Skipped 是无法导入的文件或笔记，Warnings 是已导入但有部分内容丢失或需要注意的笔记。
+type ImportResult struct {
+	Imported []string
+	Skipped  []importer.Issue
+	Warnings []importer.Issue
+}
+
+// importDrafts 逐篇创建笔记。无法创建的笔记（比如标签不足、超出体积上限）会被跳过，
+// 不影响其他笔记。先为全部笔记分配 ID, 然后才能把笔记之间的链接指向新的 ID.
+// session 是导入者的会话 (详见 DB.SessionLabel)。调用者负责加锁。
+func importDrafts(drafts []importer.Draft, skipped []importer.Issue, session string) (
+	result ImportResult) {
+	result.Skipped = skipped
+	skip := func(draft importer.Draft, err error) {
+		result.Skipped = append(result.Skipped,
+			importer.Issue{Path: draft.Path, Reason: err.Error()})
+	}
+	warn := func(draft importer.Draft, reason string) {
+		result.Warnings = append(result.Warnings,
+			importer.Issue{Path: draft.Path, Reason: reason})
+	}
+
+	notes := make([]*Note, len(drafts))
+	ids := make(map[string]string)
+	for i, draft := range drafts {
+		note, defaultTags, err := draftNote(draft)
+		if err != nil {
+			skip(draft, err)
+			continue
+		}
+		if defaultTags {
+			warn(draft, "too few tags, added the import tag group")
+		}
+		notes[i] = note
+		if draft.Key != "" {
+			ids[draft.Key] = note.ID
+		}
+	}
+
+	for i, draft := range drafts {
+		note := notes[i]
+		if note == nil {
+			continue
+		}
+		contents, broken := importer.ResolveLinks(draft.Contents, ids)
+		if err := setDraftContents(note, contents); err != nil {
+			skip(draft, err)
+			continue
+		}
+		note.SetVersionSession(session)
+		if err := insertImported(note); err != nil {
+			skip(draft, err)
+			continue
+		}
+		for _, reason := range draft.Warnings {
+			warn(draft, reason)
+		}
+		for _, key := range broken {
+			warn(draft, "link to a note that was not imported: "+key)
+		}
+		result.Imported = append(result.Imported, note.ID)
+	}
+	return
+}
+
+// draftNote 根据 draft 新建笔记（分配 ID, 设置标签与时间，但还没有内容）。
+// 如果标签少于两个，则添加 config.ImportTagGroup, 此时 defaultTags 为 true.
+// 先检查标签与内容，通过后才分配 ID, 以免被跳过的笔记占用 ID.
+func draftNote(draft importer.Draft) (note *Note, defaultTags bool, err error) {
+	tags, err := db.ResolveTags(draft.Tags)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(tags) < 2 && len(config.ImportTagGroup) > 0 {
+		if tags, err = db.ResolveTags(append(tags, config.ImportTagGroup...)); err != nil {
+			return nil, false, err
+		}
+		defaultTags = true
+	}
+	noteType := model.NewNoteType(draft.Type)
+	trial := model.NewNote("", noteType)
+	err1 := trial.SetTags(tags)
+	err2 := setDraftContents(trial, draft.Contents)
+	if err = util.WrapErrors(err1, err2); err != nil {
+		return nil, false, err
+	}
+	note = db.NewNote(noteType)
+	note.Tags = trial.Tags
+	if !draft.Created.IsZero() {
+		note.CreatedAt = formatTime(draft.Created)
+	}
+	if !draft.Updated.IsZero() {
+		note.UpdatedAt = formatTime(draft.Updated)
+	}
+	note.Deleted = draft.Deleted
+	return note, defaultTags, nil
+}
+
+// setDraftContents 把全部内容作为笔记的第一个 patch, 该版本的时间是笔记的创建时间。
+func setDraftContents(note *Note, contents string) error {
+	patch := model.MakePatch("", contents)
+	if err := note.AddPatchSetTitle(patch, strings.TrimSpace(contents)); err != nil {
+		return err
+	}
+	note.Versions[0].CreatedAt = note.CreatedAt
+	return nil
+}
+
+// insertImported 保存导入的笔记，回收站中的笔记在保存后再删除（以便正确处理标签）。
+func insertImported(note *Note) error {
+	deleted := note.Deleted
+	note.Deleted = false
+	if err := db.Insert(note); err != nil {
+		return err
+	}
+	if deleted {
+		return db.SetNoteDeleted(note.ID, true)
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	return t.Local().Format(config.ISO8601)
+}
+
+// convertFiles 按 format 把文件转换为 Draft.
+func convertFiles(format string, files []importer.File) ([]importer.Draft, []importer.Issue, error) {
+	convert, ok := importers[format]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown import format: %s", format)
+	}
+	drafts, skipped := convert(files)
+	return drafts, skipped, nil
+}
+
+// importFiles 导入上传的文件 (form: file, format), 上传的 zip 文件会被解压。
+// 格式详见 importers, 比如 markdown 详见 importer.Markdown.
+// 受 MaxBodySize 限制，较大的文件夹请使用 -import 命令。
+func importFiles(c *fiber.Ctx) error {
+	format := c.FormValue("format", "markdown")
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return fiber.NewError(400, "missing file")
+	}
+	data, err := readFormFile(fileHeader)
+	if err != nil {
+		return err
+	}
+	files, err := importer.ReadFile(fileHeader.Filename, time.Now(), data)
+	if err != nil {
+		return fiber.NewError(400, err.Error())
+	}
+	drafts, skipped, err := convertFiles(format, files)
+	if err != nil {
+		return fiber.NewError(400, err.Error())
+	}
+
+	db.Lock()
+	defer db.Unlock()
+
+	result := importDrafts(drafts, skipped, db.SessionLabel(c))
+	audit(c, model.OpBackupImport, result.Imported, "", importSummary(format, result))
+	return c.JSON(result)
+}
+
+func readFormFile(fileHeader *multipart.FileHeader) ([]byte, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+func importSummary(format string, result ImportResult) string {
+	return fmt.Sprintf("format=%s imported=%d skipped=%d",
+		format, len(result.Imported), len(result.Skipped))
+}
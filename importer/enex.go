@@ -0,0 +1,431 @@
+package importer
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ahui2016/uglynotes/settings"
+)
+
+// enexTimeLayout 是 ENEX 中的时间格式 (UTC)。
+const enexTimeLayout = "20060102T150405Z"
+
+type enexExport struct {
+	Notes []enexNote `xml:"note"`
+}
+
+type enexNote struct {
+	Title     string         `xml:"title"`
+	Content   string         `xml:"content"`
+	Created   string         `xml:"created"`
+	Updated   string         `xml:"updated"`
+	Deleted   string         `xml:"deleted"`
+	Tags      []string       `xml:"tag"`
+	Resources []enexResource `xml:"resource"`
+}
+
+type enexResource struct {
+	Data     string `xml:"data"`
+	Mime     string `xml:"mime"`
+	FileName string `xml:"resource-attributes>file-name"`
+}
+
+// ENEX 把 Evernote 导出的 .enex 文件转换为 Markdown 笔记，其他文件会被跳过。
+//
+// 笔记本的名称（即 .enex 文件名）与 Evernote 的标签都会成为 uglynotes 的标签。
+// 附件 (图片等) 以 data URI 的形式嵌入笔记中，超出 NoteSizeLimit 的附件会被省略，
+// 被省略的附件与加密内容会记录在 Draft.Warnings 中。
+func ENEX(files []File) (drafts []Draft, skipped []Issue) {
+	for _, file := range files {
+		if strings.ToLower(path.Ext(file.Path)) != ".enex" {
+			skipped = append(skipped, Issue{Path: file.Path, Reason: "unsupported file type"})
+			continue
+		}
+		var export enexExport
+		if err := decodeXML(file.Data, &export); err != nil {
+			skipped = append(skipped, Issue{Path: file.Path, Reason: err.Error()})
+			continue
+		}
+		notebook := strings.TrimSuffix(path.Base(file.Path), path.Ext(file.Path))
+		for _, note := range export.Notes {
+			notePath := file.Path + "/" + note.Title
+			draft, omitted, err := enexDraft(note, notebook)
+			if err != nil {
+				skipped = append(skipped, Issue{Path: notePath, Reason: err.Error()})
+				continue
+			}
+			draft.Path = notePath
+			for _, name := range omitted {
+				draft.Warnings = append(draft.Warnings, "omitted: "+name)
+			}
+			drafts = append(drafts, draft)
+		}
+	}
+	return
+}
+
+func decodeXML(data []byte, v interface{}) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	return decoder.Decode(v)
+}
+
+func enexDraft(note enexNote, notebook string) (draft Draft, omitted []string, err error) {
+	title := strings.TrimSpace(note.Title)
+	conv := newENMLConverter(note.Resources)
+	body, err := conv.convert(note.Content)
+	if err != nil {
+		return
+	}
+	contents := body
+	if title != "" {
+		contents = "# " + title + "\n\n" + body
+	}
+	contents, omitted = conv.embedResources(contents, settings.Config.NoteSizeLimit)
+	if strings.TrimSpace(contents) == "" {
+		return draft, nil, fmt.Errorf("empty note")
+	}
+
+	draft = Draft{
+		Type:     "Markdown",
+		Contents: contents + "\n",
+		Tags:     append(note.Tags, notebook),
+		Deleted:  note.Deleted != "",
+	}
+	draft.Created, _ = time.Parse(enexTimeLayout, strings.TrimSpace(note.Created))
+	draft.Updated, _ = time.Parse(enexTimeLayout, strings.TrimSpace(note.Updated))
+	if draft.Updated.IsZero() {
+		draft.Updated = draft.Created
+	}
+	return draft, omitted, nil
+}
+
+// enmlNode 是 ENML (Evernote 的 XHTML) 中的一个元素或一段文字 (name 为空)。
+type enmlNode struct {
+	name     string
+	attr     map[string]string
+	text     string
+	children []*enmlNode
+}
+
+func parseENML(content string) (*enmlNode, error) {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	root := &enmlNode{name: "root"}
+	stack := []*enmlNode{root}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		parent := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &enmlNode{name: strings.ToLower(t.Name.Local), attr: map[string]string{}}
+			for _, a := range t.Attr {
+				node.attr[strings.ToLower(a.Name.Local)] = a.Value
+			}
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.children = append(parent.children, &enmlNode{text: string(t)})
+		}
+	}
+	return root, nil
+}
+
+// enmlMedia 是笔记中引用的一个附件，先以占位符代替，最后再决定是否嵌入。
+type enmlMedia struct {
+	placeholder string
+	markdown    string // 嵌入后的 Markdown
+	name        string
+}
+
+type enmlConverter struct {
+	resources map[string]enexResource // key 是附件的 md5
+	media     []enmlMedia
+	omitted   []string
+}
+
+func newENMLConverter(resources []enexResource) *enmlConverter {
+	conv := &enmlConverter{resources: make(map[string]enexResource)}
+	for _, res := range resources {
+		data, err := base64.StdEncoding.DecodeString(removeSpaces(res.Data))
+		if err != nil {
+			continue
+		}
+		sum := md5.Sum(data)
+		res.Data = base64.StdEncoding.EncodeToString(data)
+		conv.resources[hex.EncodeToString(sum[:])] = res
+	}
+	return conv
+}
+
+var (
+	reSpaces     = regexp.MustCompile(`\s+`)
+	reBlankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+func removeSpaces(s string) string {
+	return reSpaces.ReplaceAllString(s, "")
+}
+
+// convert 把 ENML 转换为 Markdown, 附件暂时以占位符代替。
+func (conv *enmlConverter) convert(content string) (string, error) {
+	root, err := parseENML(content)
+	if err != nil {
+		return "", err
+	}
+	return cleanMarkdown(conv.render(root)), nil
+}
+
+// embedResources 按顺序把附件以 data URI 的形式嵌入，直至笔记体积接近 limit,
+// 返回嵌入后的内容以及被省略的附件名称。
+func (conv *enmlConverter) embedResources(contents string, limit int) (string, []string) {
+	// patch 的体积约为内容的体积加上每行一个字节，再加上开头的几行。
+	size := len(contents) + strings.Count(contents, "\n") + 200
+	for _, media := range conv.media {
+		replacement := "[" + media.name + " omitted]"
+		if size+len(media.markdown)+1 <= limit {
+			replacement = media.markdown
+			size += len(media.markdown) + 1
+		} else {
+			conv.omitted = append(conv.omitted, media.name)
+		}
+		contents = strings.Replace(contents, media.placeholder, replacement, 1)
+	}
+	return contents, conv.omitted
+}
+
+func (conv *enmlConverter) children(node *enmlNode) string {
+	var b strings.Builder
+	for _, child := range node.children {
+		b.WriteString(conv.render(child))
+	}
+	return b.String()
+}
+
+func (conv *enmlConverter) render(node *enmlNode) string {
+	if node.name == "" {
+		return reSpaces.ReplaceAllString(node.text, " ")
+	}
+	switch node.name {
+	case "head", "title", "style", "script":
+		return ""
+	case "br":
+		return "\n"
+	case "hr":
+		return "\n\n---\n\n"
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level := int(node.name[1] - '0')
+		return "\n\n" + strings.Repeat("#", level) + " " +
+			strings.TrimSpace(conv.children(node)) + "\n\n"
+	case "b", "strong":
+		return wrapInline(conv.children(node), "**")
+	case "i", "em":
+		return wrapInline(conv.children(node), "*")
+	case "s", "strike", "del":
+		return wrapInline(conv.children(node), "~~")
+	case "code", "tt":
+		return wrapInline(rawText(node), "`")
+	case "pre":
+		return codeBlock(rawText(node))
+	case "a":
+		text := strings.TrimSpace(conv.children(node))
+		href := node.attr["href"]
+		if href == "" {
+			return text
+		}
+		if text == "" || text == href {
+			return "<" + href + ">"
+		}
+		return "[" + text + "](" + href + ")"
+	case "img":
+		return "![" + node.attr["alt"] + "](" + node.attr["src"] + ")"
+	case "ul", "ol":
+		return conv.list(node)
+	case "blockquote":
+		inner := strings.TrimSpace(cleanMarkdown(conv.children(node)))
+		return "\n\n> " + strings.ReplaceAll(inner, "\n", "\n> ") + "\n\n"
+	case "table":
+		return conv.table(node)
+	case "en-todo":
+		if node.attr["checked"] == "true" {
+			return "[x] "
+		}
+		return "[ ] "
+	case "en-media":
+		return conv.mediaPlaceholder(node)
+	case "en-crypt":
+		conv.omitted = append(conv.omitted, "encrypted content")
+		return "[encrypted content omitted]"
+	case "div":
+		if strings.Contains(node.attr["style"], "-en-codeblock") {
+			return codeBlock(blockText(node))
+		}
+	}
+	if isBlock(node.name) {
+		return "\n\n" + strings.TrimSpace(conv.children(node)) + "\n\n"
+	}
+	return conv.children(node)
+}
+
+func isBlock(name string) bool {
+	switch name {
+	case "p", "div", "en-note", "section", "article", "center", "dl", "dt", "dd":
+		return true
+	}
+	return false
+}
+
+func wrapInline(s, mark string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	return mark + trimmed + mark
+}
+
+func codeBlock(code string) string {
+	return "\n\n```\n" + strings.Trim(code, "\n") + "\n```\n\n"
+}
+
+// rawText 返回保留空白的纯文字。
+func rawText(node *enmlNode) string {
+	if node.name == "" {
+		return node.text
+	}
+	if node.name == "br" {
+		return "\n"
+	}
+	var b strings.Builder
+	for _, child := range node.children {
+		b.WriteString(rawText(child))
+	}
+	return b.String()
+}
+
+// blockText 与 rawText 相同，但每个 div/p 占一行 (Evernote 的代码块中每行是一个 div)。
+func blockText(node *enmlNode) string {
+	var b strings.Builder
+	for _, child := range node.children {
+		if child.name == "div" || child.name == "p" {
+			b.WriteString(strings.TrimSuffix(blockText(child), "\n") + "\n")
+			continue
+		}
+		b.WriteString(rawText(child))
+	}
+	return b.String()
+}
+
+func (conv *enmlConverter) list(node *enmlNode) string {
+	var items []string
+	n := 0
+	for _, li := range node.children {
+		if li.name != "li" {
+			continue
+		}
+		n++
+		marker := "- "
+		if node.name == "ol" {
+			marker = fmt.Sprintf("%d. ", n)
+		}
+		item := strings.TrimSpace(conv.children(li))
+		item = reBlankLines.ReplaceAllString(strings.ReplaceAll(item, "\n\n", "\n"), "\n")
+		indent := strings.Repeat(" ", len(marker))
+		items = append(items, marker+strings.ReplaceAll(item, "\n", "\n"+indent))
+	}
+	return "\n\n" + strings.Join(items, "\n") + "\n\n"
+}
+
+func (conv *enmlConverter) table(node *enmlNode) string {
+	var rows [][]string
+	var walk func(n *enmlNode)
+	walk = func(n *enmlNode) {
+		for _, child := range n.children {
+			switch child.name {
+			case "tr":
+				var cells []string
+				for _, cell := range child.children {
+					if cell.name == "td" || cell.name == "th" {
+						text := strings.TrimSpace(conv.children(cell))
+						text = reSpaces.ReplaceAllString(text, " ")
+						cells = append(cells, strings.ReplaceAll(text, "|", `\|`))
+					}
+				}
+				rows = append(rows, cells)
+			case "thead", "tbody", "tfoot":
+				walk(child)
+			}
+		}
+	}
+	walk(node)
+	if len(rows) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\n")
+	for i, row := range rows {
+		b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			b.WriteString(strings.Repeat("| --- ", len(row)) + "|\n")
+		}
+	}
+	return b.String() + "\n"
+}
+
+func (conv *enmlConverter) mediaPlaceholder(node *enmlNode) string {
+	res, ok := conv.resources[strings.ToLower(node.attr["hash"])]
+	if !ok {
+		return ""
+	}
+	mime := res.Mime
+	if mime == "" {
+		mime = node.attr["type"]
+	}
+	name := res.FileName
+	if name == "" {
+		name = mime
+	}
+	link := "[" + name + "](data:" + mime + ";base64," + res.Data + ")"
+	if strings.HasPrefix(mime, "image/") {
+		link = "!" + link
+	}
+	media := enmlMedia{
+		placeholder: fmt.Sprintf("\x00media-%d\x00", len(conv.media)),
+		markdown:    link,
+		name:        name,
+	}
+	conv.media = append(conv.media, media)
+	return media.placeholder
+}
+
+// cleanMarkdown 去除行尾空白以及多余的空行。
+func cleanMarkdown(s string) string {
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " \t\u00a0")
+	}
+	s = strings.Join(lines, "\n")
+	return strings.TrimSpace(reBlankLines.ReplaceAllString(s, "\n\n"))
+}
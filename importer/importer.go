@@ -0,0 +1,214 @@
+// Package importer 把其他格式的笔记转换为 Draft, 再由调用者逐篇创建笔记。
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/ahui2016/uglynotes/settings"
+)
+
+// Draft 待导入的笔记。
+type Draft struct {
+	Path     string // 来源文件的路径，用于报告导入结果
+	Key      string // 其他笔记以 "[文字](note:Key)" 链接到这篇笔记，详见 ResolveLinks
+	Type     string // "Markdown" 或 "Plaintext"
+	Contents string
+	Tags     []string
+	Created  time.Time // 零值表示未知
+	Updated  time.Time // 零值表示未知
+	Deleted  bool
+
+	// Warnings 可以导入但有部分内容丢失的原因，比如被省略的附件、无法解析的链接。
+	Warnings []string
+}
+
+// Issue 无法导入（或只能部分导入）的文件或笔记，以及原因。
+type Issue struct {
+	Path   string
+	Reason string
+}
+
+// reNoteLink 匹配 "[文字](note:Key)", 其中 Key 经过 url.PathEscape.
+var reNoteLink = regexp.MustCompile(`\[([^\]]*)\]\(note:([^)\s]+)\)`)
+
+// noteLink 返回指向另一篇待导入笔记的链接。
+func noteLink(text, key string) string {
+	return "[" + text + "](note:" + url.PathEscape(key) + ")"
+}
+
+// ResolveLinks 把 contents 中的 "[文字](note:Key)" 改为指向 uglynotes 笔记的 [[ID|文字]] 链接,
+// ids 的 key 是 Draft.Key, value 是笔记的 ID. 找不到 ID 的链接（比如目标笔记被跳过）
+// 只保留文字，并返回这些链接的 Key.
+func ResolveLinks(contents string, ids map[string]string) (string, []string) {
+	var broken []string
+	contents = reNoteLink.ReplaceAllStringFunc(contents, func(link string) string {
+		m := reNoteLink.FindStringSubmatch(link)
+		key, err := url.PathUnescape(m[2])
+		if err != nil {
+			key = m[2]
+		}
+		id, ok := ids[key]
+		if !ok {
+			broken = append(broken, key)
+			return m[1]
+		}
+		return model.WikiLink(id, m[1])
+	})
+	return contents, broken
+}
+
+// File 待转换的文件，Path 是以 "/" 分隔的相对路径。
+type File struct {
+	Path    string
+	ModTime time.Time
+	Data    []byte
+}
+
+// Open 读取一个文件夹内的全部文件（包括子文件夹）或一个 zip 文件内的全部文件；
+// 如果 name 是其他文件，则只读取该文件。
+func Open(name string) ([]File, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return ReadDir(name)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ReadFile(filepath.Base(name), info.ModTime(), data)
+}
+
+// ReadFile 如果 name 是 zip 文件，则返回其中的全部文件，否则只返回该文件本身。
+func ReadFile(name string, modTime time.Time, data []byte) ([]File, error) {
+	if strings.ToLower(path.Ext(name)) == ".zip" {
+		return ReadZip(data)
+	}
+	return []File{{Path: name, ModTime: modTime, Data: data}}, nil
+}
+
+// ReadDir 读取文件夹 dir 内的全部文件（包括子文件夹），隐藏文件与隐藏文件夹除外。
+func ReadDir(dir string) (files []File, err error) {
+	err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && isHidden(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		files = append(files, File{Path: rel, ModTime: info.ModTime(), Data: data})
+		return nil
+	})
+	return
+}
+
+// ReadZip 读取 zip 内的全部文件，隐藏文件与隐藏文件夹除外。
+// 为了防止 zip 炸弹，每个文件解压后不可超过 NoteSizeLimit,
+// 全部文件解压后合计不可超过 DatabaseCapacity.
+func ReadZip(data []byte) (files []File, err error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	fileLimit := int64(settings.Config.NoteSizeLimit)
+	totalLimit := int64(settings.Config.DatabaseCapacity)
+	var total int64
+	for _, f := range zr.File {
+		name := path.Clean(strings.TrimPrefix(f.Name, "/"))
+		if f.FileInfo().IsDir() || isHidden(name) {
+			continue
+		}
+		data, err := readZipFile(f, fileLimit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if total += int64(len(data)); total > totalLimit {
+			return nil, fmt.Errorf("zip: total size exceeds %d bytes", totalLimit)
+		}
+		files = append(files, File{Path: name, ModTime: f.Modified, Data: data})
+	}
+	return
+}
+
+// readZipFile 解压一个文件，超过 limit 时返回错误（不信任 zip 中记录的体积）。
+func readZipFile(f *zip.File, limit int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("file size exceeds %d bytes", limit)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("file size exceeds %d bytes", limit)
+	}
+	return data, nil
+}
+
+// isHidden 判断路径中是否有以 "." 开头的部分 (比如 .git, .obsidian, .DS_Store)。
+func isHidden(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// timeLayouts 是导入时可识别的时间格式。
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999-07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTime 尝试以多种常见格式解析时间，不含时区的时间视为本地时间。
+func ParseTime(s string) (t time.Time, ok bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return
+}
@@ -0,0 +1,213 @@
+package importer
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Joplin 的数据类型 (type_)
+const (
+	joplinNote     = 1
+	joplinFolder   = 2
+	joplinResource = 4
+	joplinTag      = 5
+	joplinNoteTag  = 6
+)
+
+var (
+	// reJoplinLink 匹配 Joplin 的内部链接 [文字](:/id) 与图片 ![文字](:/id).
+	reJoplinLink = regexp.MustCompile(`(!?)\[([^\]]*)\]\(:/([0-9a-fA-F]{32})(#[^)\s]*)?\)`)
+
+	// reJoplinMeta 匹配 RAW 格式文件末尾的 "key: value" 行。
+	reJoplinMeta = regexp.MustCompile(`^([a-z_]+):\s?(.*)$`)
+)
+
+// joplinItem 是 Joplin 导出的一个项目（笔记、笔记本、标签等）。
+type joplinItem struct {
+	path     string
+	id       string
+	parentID string
+	title    string
+	body     string
+	typ      int
+	created  time.Time
+	updated  time.Time
+	deleted  bool
+	noteID   string // note_tag
+	tagID    string // note_tag
+}
+
+// Joplin 导入 Joplin 以 "JSON Export Directory" 或 "RAW - Joplin Export Directory"
+// 格式导出的文件夹 (或 zip)。笔记本的各级名称、Joplin 的标签以及正文中的 #标签 都会成为标签；
+// 笔记之间的链接 [文字](:/id) 会被改为指向 uglynotes 笔记的链接，附件不会被导入。
+func Joplin(files []File) (drafts []Draft, skipped []Issue) {
+	items := make(map[string]*joplinItem)
+	var order []*joplinItem
+	for _, file := range files {
+		item, ok := parseJoplinItem(file)
+		if !ok {
+			skipped = append(skipped, Issue{Path: file.Path, Reason: "not a Joplin item"})
+			continue
+		}
+		items[item.id] = item
+		order = append(order, item)
+	}
+
+	noteTags := make(map[string][]string)
+	for _, item := range order {
+		if item.typ == joplinNoteTag {
+			if tag, ok := items[item.tagID]; ok {
+				noteTags[item.noteID] = append(noteTags[item.noteID], tag.title)
+			}
+		}
+	}
+
+	for _, item := range order {
+		if item.typ != joplinNote {
+			continue
+		}
+		body := withTitle("Markdown", strings.TrimSpace(item.title), item.body)
+		if strings.TrimSpace(body) == "" {
+			skipped = append(skipped, Issue{Path: item.path, Reason: "empty note"})
+			continue
+		}
+		draft := Draft{
+			Path:    item.path,
+			Key:     item.id,
+			Type:    "Markdown",
+			Tags:    append(joplinFolders(items, item.parentID), noteTags[item.id]...),
+			Created: item.created,
+			Updated: item.updated,
+			Deleted: item.deleted,
+		}
+		draft.Tags = append(draft.Tags, Hashtags(body)...)
+		draft.Contents, draft.Warnings = rewriteJoplinLinks(items, body)
+		drafts = append(drafts, draft)
+	}
+	return
+}
+
+// joplinFolders 返回笔记本及其上级笔记本的名称。
+func joplinFolders(items map[string]*joplinItem, id string) (names []string) {
+	for depth := 0; id != "" && depth < 100; depth++ {
+		folder, ok := items[id]
+		if !ok || folder.typ != joplinFolder {
+			break
+		}
+		names = append([]string{folder.title}, names...)
+		id = folder.parentID
+	}
+	return
+}
+
+func rewriteJoplinLinks(items map[string]*joplinItem, body string) (string, []string) {
+	var warnings []string
+	body = reJoplinLink.ReplaceAllStringFunc(body, func(link string) string {
+		m := reJoplinLink.FindStringSubmatch(link)
+		target, ok := items[strings.ToLower(m[3])]
+		switch {
+		case ok && target.typ == joplinNote && m[1] == "":
+			return noteLink(m[2], target.id)
+		case ok && target.typ == joplinResource:
+			warnings = append(warnings, "attachment not imported: "+target.title)
+		default:
+			warnings = append(warnings, "broken link: "+m[3])
+		}
+		return link
+	})
+	return body, warnings
+}
+
+func parseJoplinItem(file File) (*joplinItem, bool) {
+	switch strings.ToLower(path.Ext(file.Path)) {
+	case ".json":
+		return parseJoplinJSON(file)
+	case ".md":
+		return parseJoplinRaw(file)
+	}
+	return nil, false
+}
+
+func parseJoplinJSON(file File) (*joplinItem, bool) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(file.Data, &fields); err != nil {
+		return nil, false
+	}
+	values := make(map[string]string)
+	for key, value := range fields {
+		switch v := value.(type) {
+		case string:
+			values[key] = v
+		case float64:
+			values[key] = strconv.FormatInt(int64(v), 10)
+		}
+	}
+	return newJoplinItem(file.Path, values)
+}
+
+// parseJoplinRaw 解析 RAW 格式：第一行是标题，然后是空行与正文，
+// 最后是以空行隔开的 "key: value" 元数据。
+func parseJoplinRaw(file File) (*joplinItem, bool) {
+	lines := strings.Split(strings.TrimRight(string(file.Data), "\n"), "\n")
+	values := make(map[string]string)
+	i := len(lines) - 1
+	for ; i >= 0; i-- {
+		m := reJoplinMeta.FindStringSubmatch(strings.TrimRight(lines[i], "\r"))
+		if m == nil {
+			break
+		}
+		values[m[1]] = m[2]
+	}
+	if i >= 0 && strings.TrimSpace(lines[i]) != "" {
+		return nil, false
+	}
+	content := lines[:i+1]
+	if len(content) > 0 {
+		values["title"] = content[0]
+	}
+	if len(content) > 2 {
+		values["body"] = strings.TrimRight(strings.Join(content[2:], "\n"), "\n")
+	}
+	return newJoplinItem(file.Path, values)
+}
+
+func newJoplinItem(filePath string, values map[string]string) (*joplinItem, bool) {
+	typ, err := strconv.Atoi(values["type_"])
+	if err != nil || values["id"] == "" {
+		return nil, false
+	}
+	item := &joplinItem{
+		path:     filePath,
+		id:       strings.ToLower(values["id"]),
+		parentID: strings.ToLower(values["parent_id"]),
+		title:    values["title"],
+		body:     values["body"],
+		typ:      typ,
+		noteID:   strings.ToLower(values["note_id"]),
+		tagID:    strings.ToLower(values["tag_id"]),
+		deleted:  values["deleted_time"] != "" && values["deleted_time"] != "0",
+	}
+	item.created = joplinTime(values["user_created_time"], values["created_time"])
+	item.updated = joplinTime(values["user_updated_time"], values["updated_time"])
+	return item, true
+}
+
+// joplinTime 返回第一个有效的时间。JSON 格式中的时间是毫秒数，RAW 格式中是 ISO 8601.
+func joplinTime(values ...string) time.Time {
+	for _, value := range values {
+		if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+			if ms > 0 {
+				return time.Unix(0, ms*int64(time.Millisecond))
+			}
+			continue
+		}
+		if t, ok := ParseTime(value); ok {
+			return t
+		}
+	}
+	return time.Time{}
+}
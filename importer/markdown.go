@@ -0,0 +1,163 @@
+package importer
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ahui2016/uglynotes/frontmatter"
+)
+
+// reHashtag 匹配正文中的 #标签 (Markdown 的标题 "# xxx" 有空格，因此不会被匹配)。
+var reHashtag = regexp.MustCompile(
+	`(?:^|\s)#([^\s#.,;:!?()\[\]{}<>"'` + "`" + `，。；：！？、]+)`)
+
+var reDigits = regexp.MustCompile(`^\d+$`)
+
+// Markdown 把 .md, .markdown 与 .txt 文件转换为 Draft, 其他文件会被跳过。
+//
+// 优先使用文件开头的 front matter (title, type, tags, created, updated, deleted);
+// 如果 front matter 中没有标签，则把文件所在的各级文件夹名称以及正文中的 #标签 作为标签。
+// 如果找不到创建时间或更新时间，则使用文件的修改时间。
+// 由 /api/backup/archive 导出的 trash 文件夹中的笔记会被导入到回收站。
+func Markdown(files []File) (drafts []Draft, skipped []Issue) {
+	for _, file := range files {
+		draft, reason := markdownDraft(file)
+		if reason != "" {
+			skipped = append(skipped, Issue{Path: file.Path, Reason: reason})
+			continue
+		}
+		drafts = append(drafts, draft)
+	}
+	return
+}
+
+func markdownDraft(file File) (draft Draft, reason string) {
+	noteType := typeByExt(file.Path)
+	if noteType == "" {
+		return draft, "unsupported file type"
+	}
+	if !utf8.Valid(file.Data) {
+		return draft, "not a valid UTF-8 text file"
+	}
+	meta, body, _ := frontmatter.Parse(string(file.Data))
+	switch strings.ToLower(meta.Type) {
+	case "markdown":
+		noteType = "Markdown"
+	case "plaintext":
+		noteType = "Plaintext"
+	case "encrypted":
+		return draft, "encrypted notes cannot be imported"
+	}
+
+	if strings.TrimSpace(body) == "" {
+		return draft, "empty file"
+	}
+	title := meta.Title
+	if title == "" {
+		title = fileTitle(file.Path)
+	}
+	body = withTitle(noteType, title, body)
+
+	dirs := pathTags(file.Path)
+	inTrash := len(dirs) > 0 && dirs[0] == "trash"
+	if inTrash {
+		dirs = dirs[1:]
+	}
+	draft = Draft{
+		Path:     file.Path,
+		Type:     noteType,
+		Contents: body,
+		Tags:     meta.Tags,
+		Deleted:  meta.Deleted || inTrash,
+	}
+	if len(draft.Tags) == 0 {
+		draft.Tags = append(dirs, Hashtags(body)...)
+	}
+	draft.Created, draft.Updated = draftTimes(meta, file)
+	return draft, ""
+}
+
+func typeByExt(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown":
+		return "Markdown"
+	case ".txt":
+		return "Plaintext"
+	}
+	return ""
+}
+
+// fileTitle 以文件名作为标题，并去除 /api/backup/archive 导出时添加在开头的 ID.
+func fileTitle(name string) string {
+	title := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	if i := strings.Index(title, " "); i > 0 {
+		title = title[i+1:]
+	}
+	return strings.TrimSpace(title)
+}
+
+// withTitle 如果正文的第一行不是标题，则在正文开头添加标题
+// (因为 uglynotes 以第一行作为笔记的标题)。
+func withTitle(noteType, title, body string) string {
+	if title == "" {
+		return body
+	}
+	firstLine := strings.TrimSpace(body)
+	if i := strings.Index(firstLine, "\n"); i >= 0 {
+		firstLine = strings.TrimSpace(firstLine[:i])
+	}
+	if strings.TrimSpace(strings.TrimLeft(firstLine, "#")) == title {
+		return body
+	}
+	if noteType == "Markdown" {
+		return "# " + title + "\n\n" + body
+	}
+	return title + "\n\n" + body
+}
+
+// pathTags 返回文件所在的各级文件夹名称。
+func pathTags(name string) (tags []string) {
+	dir := path.Dir(name)
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	return strings.Split(dir, "/")
+}
+
+// Hashtags 返回正文中的 #标签 (忽略代码块与纯数字，比如 #1)。
+func Hashtags(body string) (tags []string) {
+	inCode := false
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		for _, m := range reHashtag.FindAllStringSubmatch(line, -1) {
+			if !reDigits.MatchString(m[1]) {
+				tags = append(tags, m[1])
+			}
+		}
+	}
+	return
+}
+
+func draftTimes(meta frontmatter.Meta, file File) (created, updated time.Time) {
+	created, ok := ParseTime(meta.Created)
+	if !ok {
+		created = file.ModTime
+	}
+	updated, ok = ParseTime(meta.Updated)
+	if !ok {
+		updated = file.ModTime
+	}
+	if !updated.IsZero() && updated.Before(created) {
+		updated = created
+	}
+	return
+}
@@ -0,0 +1,153 @@
+package importer
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	// reWikilink 匹配 [[笔记]], [[笔记|别名]], [[笔记#标题]] 以及嵌入 ![[文件]].
+	reWikilink = regexp.MustCompile(`(!?)\[\[([^\[\]|]+?)(?:\|([^\[\]]*))?\]\]`)
+
+	// reMarkdownFileLink 匹配指向 .md 文件的 Markdown 链接 [文字](文件.md#标题).
+	reMarkdownFileLink = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s]+?\.md)(#[^)\s]*)?\)`)
+)
+
+// Obsidian 导入 Obsidian 的 vault (一个文件夹或 zip) 中的 .md 文件，附件等其他文件会被跳过。
+//
+// 与 Markdown 不同的是，front matter 中的标签、各级文件夹名称以及正文中的 #标签 都会成为标签；
+// [[笔记]], [[笔记|别名]], [[笔记#标题]] 以及指向 .md 文件的链接会被改为指向 uglynotes 笔记的链接，
+// 找不到目标的链接与嵌入的附件保持原样，并记录在 Draft.Warnings 中。
+func Obsidian(files []File) (drafts []Draft, skipped []Issue) {
+	vault := newVaultIndex(files)
+	for _, file := range files {
+		if strings.ToLower(path.Ext(file.Path)) != ".md" {
+			skipped = append(skipped, Issue{Path: file.Path, Reason: "unsupported file type"})
+			continue
+		}
+		draft, reason := markdownDraft(file)
+		if reason != "" {
+			skipped = append(skipped, Issue{Path: file.Path, Reason: reason})
+			continue
+		}
+		draft.Key = noteKey(file.Path)
+		draft.Tags = append(draft.Tags, pathTags(file.Path)...)
+		draft.Tags = append(draft.Tags, Hashtags(draft.Contents)...)
+		draft.Contents, draft.Warnings = vault.rewriteLinks(file.Path, draft.Contents)
+		drafts = append(drafts, draft)
+	}
+	return
+}
+
+// noteKey 以去除扩展名的路径作为 Draft.Key.
+func noteKey(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// vaultIndex 用于按照 Obsidian 的规则查找链接的目标：
+// 先按路径查找，再按文件名查找（同名时使用路径最短的文件）。
+type vaultIndex struct {
+	paths map[string]string // 小写的 noteKey -> noteKey
+	names map[string]string // 小写的文件名（不含扩展名） -> noteKey
+}
+
+func newVaultIndex(files []File) *vaultIndex {
+	vault := &vaultIndex{
+		paths: make(map[string]string),
+		names: make(map[string]string),
+	}
+	for _, file := range files {
+		if strings.ToLower(path.Ext(file.Path)) != ".md" {
+			continue
+		}
+		key := noteKey(file.Path)
+		vault.paths[strings.ToLower(key)] = key
+		name := strings.ToLower(path.Base(key))
+		if old, ok := vault.names[name]; !ok || len(key) < len(old) {
+			vault.names[name] = key
+		}
+	}
+	return vault
+}
+
+// resolve 查找从 from 文件链接到 target 的笔记。
+func (vault *vaultIndex) resolve(from, target string) (key string, ok bool) {
+	if i := strings.Index(target, "#"); i >= 0 {
+		target = target[:i]
+	}
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return noteKey(from), true // [[#标题]] 指向本笔记
+	}
+	if ext := path.Ext(target); strings.ToLower(ext) == ".md" {
+		target = strings.TrimSuffix(target, ext)
+	}
+	lower := strings.ToLower(target)
+	if key, ok = vault.paths[lower]; ok {
+		return
+	}
+	if key, ok = vault.paths[strings.ToLower(path.Join(path.Dir(from), target))]; ok {
+		return
+	}
+	key, ok = vault.names[path.Base(lower)]
+	return
+}
+
+// rewriteLinks 把 contents 中的内部链接改为 "[文字](note:Key)" (代码块中的内容除外)。
+func (vault *vaultIndex) rewriteLinks(from, contents string) (string, []string) {
+	var warnings []string
+	rewrite := func(line string) string {
+		line = reWikilink.ReplaceAllStringFunc(line, func(link string) string {
+			m := reWikilink.FindStringSubmatch(link)
+			embed, target, alias := m[1] == "!", m[2], m[3]
+			key, ok := vault.resolve(from, target)
+			if !ok {
+				if embed {
+					warnings = append(warnings, "attachment not imported: "+target)
+				} else {
+					warnings = append(warnings, "broken link: "+target)
+				}
+				return link
+			}
+			if alias == "" {
+				alias = target
+			}
+			return noteLink(alias, key)
+		})
+		return reMarkdownFileLink.ReplaceAllStringFunc(line, func(link string) string {
+			m := reMarkdownFileLink.FindStringSubmatch(link)
+			if strings.Contains(m[2], "://") {
+				return link
+			}
+			target, err := url.PathUnescape(m[2])
+			if err != nil {
+				target = m[2]
+			}
+			key, ok := vault.resolve(from, target)
+			if !ok {
+				warnings = append(warnings, "broken link: "+target)
+				return link
+			}
+			return noteLink(m[1], key)
+		})
+	}
+	return mapLinesOutsideCode(contents, rewrite), warnings
+}
+
+// mapLinesOutsideCode 对代码块以外的每一行调用 fn.
+func mapLinesOutsideCode(contents string, fn func(line string) string) string {
+	lines := strings.Split(contents, "\n")
+	inCode := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if !inCode {
+			lines[i] = fn(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
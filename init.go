@@ -14,9 +14,13 @@ import (
 )
 
 var (
-	cfgFlag      = flag.String("config", "", "run with a config file")
-	dbDirFlag    = flag.String("dir", "", "database directory")
-	settingsFile = "settings.json"
+	cfgFlag          = flag.String("config", "", "run with a config file")
+	dbDirFlag        = flag.String("dir", "", "database directory")
+	rotateKeyFlag    = flag.Bool("rotate-key", false, "re-encrypt notes with a new passphrase")
+	importFlag       = flag.String("import", "", "import notes from a folder, a zip file or a file")
+	importFormatFlag = flag.String("import-format", "markdown", "format of the notes to import")
+	exportGitFlag    = flag.String("export-git", "", "export the history of all notes as a git repository")
+	settingsFile     = "settings.json"
 )
 
 var (
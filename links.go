@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/ahui2016/uglynotes/database"
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/ahui2016/uglynotes/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+// getOutLinks 返回笔记中的全部 [[id]] 或 [[标题]] 链接及其目标。
+func getOutLinks(c *fiber.Ctx) error {
+	links, err := db.OutLinks(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	if links == nil {
+		links = []database.OutLink{}
+	}
+	return c.JSON(links)
+}
+
+// getBackLinks 返回链接到该笔记的全部笔记 (不含内容)。
+func getBackLinks(c *fiber.Ctx) error {
+	notes, err := db.BackLinks(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	if notes == nil {
+		notes = []Note{}
+	}
+	return c.JSON(notes)
+}
+
+// rebuildLinks 根据全部笔记的最新内容重新生成全部链接，
+// 用于升级前已有的笔记。
+func rebuildLinks(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	if err := db.RebuildLinks(); err != nil {
+		return err
+	}
+	audit(c, model.OpBackupLinks, nil, "", "")
+	return nil
+}
+
+// getGraph 返回笔记、标签及其关系组成的知识图谱，可用参数:
+// tags (以空格分隔，只包括含有全部这些标签的笔记), since, until (笔记的更新时间)。
+func getGraph(c *fiber.Ctx) error {
+	since, err1 := getTimeQuery(c, "since", false)
+	until, err2 := getTimeQuery(c, "until", true)
+	if err := util.WrapErrors(err1, err2); err != nil {
+		return fiber.NewError(400, err.Error())
+	}
+	graph, err := db.Graph(database.GraphFilter{
+		Tags:  strings.Fields(c.Query("tags")),
+		Since: since,
+		Until: until,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(graph)
+}
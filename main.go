@@ -11,6 +11,21 @@ func main() {
 	defer db.Close()
 	defer db2.Close()
 
+	if *rotateKeyFlag {
+		rotateKeyCommand()
+		return
+	}
+	if *exportGitFlag != "" {
+		exportGitCommand(*exportGitFlag)
+		return
+	}
+	if *importFlag != "" {
+		importCommand(*importFormatFlag, *importFlag)
+		return
+	}
+
+	go runRetention()
+
 	app := fiber.New(fiber.Config{
 		BodyLimit:    config.MaxBodySize,
 		Concurrency:  10,
@@ -33,14 +48,11 @@ func main() {
 	app.Use("/home", checkLoginHTML)
 	app.Get("/home", homePage)
 	app.Get("/login", loginPage)
-	app.Post("/login", loginHandler)
+	app.Post("/login", checkLoginCSRF, loginHandler)
 	app.Get("/check", checkLogin)
 	app.Get("/converter", converterPage)
-
-	app.Get("/reset-all-tags", func(c *fiber.Ctx) error {
-		return db.ResetAllTags()
-	})
-	app.Get("/import-notes", importNotes)
+	app.Get("/s/:token", sharedNotePage)
+	app.Get("/s/:token/raw", sharedNoteRaw)
 
 	htmlPage := app.Group("/html", checkLoginHTML)
 	htmlPage.Get("/index", indexPage)
@@ -55,11 +67,13 @@ func main() {
 	htmlPage.Get("/tags", tagsPage)
 	htmlPage.Get("/search", searchPage)
 	htmlPage.Get("/tag/groups", tagGroupsPage)
+	htmlPage.Get("/totp", totpPage)
 
-	api := app.Group("/api", checkLoginJSON)
+	api := app.Group("/api", checkLoginJSON, checkCSRF)
 	api.Get("/note/all", getAllNotes)
 	api.Get("/note/deleted", getDeletedNotes)
 	api.Get("/note/all/size", notesSizeHandler)
+	api.Get("/note/encryption-scheme", encryptionScheme)
 
 	api.Post("/note", newNoteHandler)
 	api.Get("/note/:id", getNoteHandler)
@@ -69,13 +83,33 @@ func main() {
 	api.Put("/note/:id/type", changeType)
 	api.Put("/note/:id/tags", updateNoteTags)
 
-	api.Delete("/note/:id/history", deleteNoteHistories)
+	api.Get("/note/:id/history", getNoteHistory)
+	api.Get("/note/:id/diff", getNoteDiff)
+	api.Get("/note/:id/blame", getNoteBlame)
+	api.Get("/note/:id/outlinks", getOutLinks)
+	api.Get("/note/:id/backlinks", getBackLinks)
+	api.Post("/note/:id/restore", restoreNote)
+	api.Post("/note/:id/fork", forkNote)
+	api.Get("/note/:id/milestones", getMilestones)
+	api.Post("/note/:id/milestone", addMilestone)
+	api.Delete("/note/:id/milestone/:version", deleteMilestone)
+	api.Delete("/note/:id/history", compactHistory)
+	api.Post("/note/:id/share", addShare)
+	api.Get("/note/:id/shares", getNoteShares)
+	api.Delete("/share/:token", deleteShare)
+
+	api.Get("/graph", getGraph)
 
 	api.Get("/tag/all", getAllTags)
 	api.Get("/tag/all-by-date", allTagsByDate)
+	api.Get("/tag/tree", getTagTree)
 	api.Get("/tag/:name/notes", getNotesByTag)
 	api.Put("/tag", renameTag)
+	api.Post("/tag/merge", mergeTags)
 	api.Delete("/tag/:name", deleteTag)
+	api.Get("/tag/alias/all", getTagAliases)
+	api.Post("/tag/alias", addTagAlias)
+	api.Delete("/tag/alias/:alias", deleteTagAlias)
 	api.Get("/tag/group/all", allTagGroups)
 	api.Post("/tag/group", addTagGroup)
 	api.Delete("/tag/group/:id", deleteTagGroup)
@@ -85,8 +119,22 @@ func main() {
 	api.Get("/search/title/:pattern", searchTitle)
 
 	api.Get("/backup/db", downloadDatabase)
-	api.Get("/backup/export", exportAllNotes)
+	api.Post("/backup/export", exportAllNotes)
+	api.Get("/backup/archive", exportArchive)
+	api.Get("/backup/git", exportGit)
 	api.Get("/backup/json", downloadDatabaseJSON)
+	api.Post("/backup/reset-all-tags", resetAllTags)
+	api.Post("/backup/rebuild-links", rebuildLinks)
+	api.Post("/backup/import-notes", importNotes)
+	api.Post("/backup/import", importFiles)
+
+	api.Get("/audit", getAudit)
+	api.Get("/audit/export", exportAudit)
+
+	api.Get("/totp", twoFactorStatus)
+	api.Post("/totp/enroll", enrollTwoFactor)
+	api.Post("/totp/confirm", confirmTwoFactor)
+	api.Post("/totp/disable", disableTwoFactor)
 
 	log.Fatal(app.Listen(config.Address))
 }
@@ -1,11 +1,23 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+const (
+	// csrfCookie 不设 HTTPOnly, 以便前端 js 读取后放进 csrfHeader 中提交。
+	csrfCookie   = "uglynotesCSRF"
+	csrfHeader   = "X-CSRF-Token"
+	csrfFormKey  = "csrf-token"
+	csrfTokenLen = 32
+)
+
 func jsonMessage(c *fiber.Ctx, msg string) error {
 	return c.Status(200).JSON(fiber.Map{"message": msg})
 }
@@ -33,6 +45,12 @@ func checkLoginHTML(c *fiber.Ctx) error {
 		}
 		return c.Redirect("/login")
 	}
+	if requireTwoFactor(c, "/html/totp", "/static/totp.js") {
+		return c.Redirect("/html/totp")
+	}
+	if err := ensureCSRFCookie(c); err != nil {
+		return err
+	}
 	return c.Next()
 }
 
@@ -40,9 +58,26 @@ func checkLoginJSON(c *fiber.Ctx) error {
 	if isLoggedOut(c) {
 		return jsonError(c, "Require Login", fiber.StatusUnauthorized)
 	}
+	if requireTwoFactor(c, "/api/totp") {
+		return jsonError(c, "Require 2FA", fiber.StatusForbidden)
+	}
 	return c.Next()
 }
 
+// requireTwoFactor reports whether the session must pass 2FA (settings: Require2FA)
+// before visiting the current path. 以 allowed 开头的路径用于启用两步验证，不受限制。
+func requireTwoFactor(c *fiber.Ctx, allowed ...string) bool {
+	if !config.Require2FA || db.SessionTwoFactor(c) {
+		return false
+	}
+	for _, prefix := range allowed {
+		if strings.HasPrefix(c.Path(), prefix) {
+			return false
+		}
+	}
+	return true
+}
+
 func isLoggedIn(c *fiber.Ctx) bool {
 	return db.SessionCheck(c)
 }
@@ -59,3 +94,72 @@ func checkPasswordTry(c *fiber.Ctx) error {
 	}
 	return nil
 }
+
+// checkCSRF 要求 /api 下的全部非 GET 请求都携带与会话绑定的 CSRF token,
+// 可通过 csrfHeader 或表单项 csrfFormKey 提交。
+// 必须放在 checkLoginJSON 之后。
+func checkCSRF(c *fiber.Ctx) error {
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		if err := ensureCSRFCookie(c); err != nil {
+			return err
+		}
+		return c.Next()
+	}
+	token := c.Get(csrfHeader)
+	if token == "" {
+		token = c.FormValue(csrfFormKey)
+	}
+	want := db.SessionCSRF(c)
+	if want == "" || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		return jsonError(c, "Invalid CSRF Token", fiber.StatusForbidden)
+	}
+	return c.Next()
+}
+
+// checkLoginCSRF 防止 login CSRF. 登入前还没有会话，因此采用 double-submit cookie:
+// 打开登入页面时设置 csrfCookie, 提交的 token 必须与该 cookie 相同。
+func checkLoginCSRF(c *fiber.Ctx) error {
+	token := c.Get(csrfHeader)
+	if token == "" {
+		token = c.FormValue(csrfFormKey)
+	}
+	want := c.Cookies(csrfCookie)
+	if want == "" || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		return jsonError(c, "Invalid CSRF Token", fiber.StatusForbidden)
+	}
+	return c.Next()
+}
+
+// ensureCSRFCookie 确保前端能读取到 CSRF token (浏览器关闭后该 cookie 失效，
+// 下次访问页面时会重新设置)。升级前已登入的会话没有 token, 此时自动生成一个。
+func ensureCSRFCookie(c *fiber.Ctx) error {
+	token := db.SessionCSRF(c)
+	if token == "" {
+		token = newCSRFToken()
+		if err := db.SessionSetCSRF(c, token); err != nil {
+			return err
+		}
+	}
+	if c.Cookies(csrfCookie) != token {
+		setCSRFCookie(c, token)
+	}
+	return nil
+}
+
+func setCSRFCookie(c *fiber.Ctx, token string) {
+	c.Cookie(&fiber.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		SameSite: "Strict",
+	})
+}
+
+func newCSRFToken() string {
+	b := make([]byte, csrfTokenLen)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,38 @@
+package model
+
+import "strings"
+
+// TagAlias 标签的别名，例如 "Go" 与 "go语言" 都是 "golang" 的别名。
+// 新增或修改笔记的标签以及搜索标签时，别名会被替换为 Tag.
+// 别名不区分大小写，Alias 保存的是 AliasKey 的结果。
+type TagAlias struct {
+	Alias     string `storm:"id"`
+	Tag       string `storm:"index"`
+	CreatedAt string // ISO8601
+}
+
+// NewTagAlias .
+func NewTagAlias(alias, tag string) *TagAlias {
+	return &TagAlias{
+		Alias:     AliasKey(alias),
+		Tag:       tag,
+		CreatedAt: TimeNow(),
+	}
+}
+
+// AliasKey 返回别名的规范写法 (小写)，保存与查找别名时都使用它。
+func AliasKey(alias string) string {
+	return strings.ToLower(alias)
+}
+
+// ResolveTag 把别名替换为对应的标签，aliases 是 AliasKey(别名) → 标签。
+// 别名的子标签也会被替换，例如 "vim" 是 "editor/vim" 的别名时，
+// "vim/plugin" 变为 "editor/vim/plugin".
+func ResolveTag(name string, aliases map[string]string) string {
+	for _, alias := range append([]string{name}, TagAncestors(name)...) {
+		if tag, ok := aliases[AliasKey(alias)]; ok {
+			return MoveTagName(name, alias, tag)
+		}
+	}
+	return name
+}
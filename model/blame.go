@@ -0,0 +1,73 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// BlameLine 当前内容中的一行，以及最后修改该行的版本。
+type BlameLine struct {
+	Line      int    // 行号，从 1 开始
+	Text      string // 不含行尾的换行符
+	Version   int    // 从 1 开始
+	CreatedAt string // 该版本的创建时间 (ISO8601), 详见 PatchTime
+}
+
+// Blame 逐个应用 patch, 找出当前内容的每一行最后是在哪个版本被修改的。
+// 客户端加密的笔记无法在服务器端还原内容。
+func (note *Note) Blame() (blame []BlameLine, err error) {
+	if note.IsEncrypted() {
+		return nil, errors.New("cannot reconstruct an encrypted note")
+	}
+	var (
+		lines   []string
+		origins []int // 与 lines 一一对应，每一行最后被修改的版本
+	)
+	for i, patch := range note.Patches {
+		hunks, err := ParsePatch(patch)
+		if err != nil {
+			return nil, fmt.Errorf("version %d: %w", i+1, err)
+		}
+		if lines, err = applyHunks(lines, hunks); err != nil {
+			return nil, fmt.Errorf("version %d: %w", i+1, err)
+		}
+		origins = blameHunks(origins, hunks, i+1)
+	}
+	for i, line := range lines {
+		blame = append(blame, BlameLine{
+			Line:      i + 1,
+			Text:      strings.TrimSuffix(line, "\n"),
+			Version:   origins[i],
+			CreatedAt: note.PatchTime(origins[i] - 1),
+		})
+	}
+	return blame, nil
+}
+
+// blameHunks 与 applyHunks 的处理方式相同，但处理的是每一行的版本号，
+// 新增的行标记为 version. 调用前必须先用 applyHunks 检查 hunks 是否有效。
+func blameHunks(old []int, hunks []Hunk, version int) (result []int) {
+	pos := 0
+	for _, hunk := range hunks {
+		start := hunk.OldStart - 1
+		if hunk.OldLines == 0 {
+			start = hunk.OldStart
+		}
+		result = append(result, old[pos:start]...)
+		pos = start
+
+		for _, line := range hunk.Lines {
+			switch line[0] {
+			case ' ':
+				result = append(result, old[pos])
+				pos++
+			case '-':
+				pos++
+			case '+':
+				result = append(result, version)
+			}
+		}
+	}
+	return append(result, old[pos:]...)
+}
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Compact 压缩历史：把第 before 个版本之前的全部版本合并为一个基础 patch,
+// 但 keep 中的版本 (例如受保护的里程碑) 与最新版本总是保留，仍然可以重建。
+// 保留下来的相邻版本之间的 patch 原样保留，不相邻的则重新生成。
+// 返回旧版本号到新版本号的对应关系 (被删除的版本不在其中)，
+// 没有被保留的版本上的里程碑会被删除。
+func (note *Note) Compact(before int, keep []int) (renumber map[int]int, err error) {
+	if note.IsEncrypted() {
+		return nil, errors.New("cannot compact an encrypted note")
+	}
+	n := len(note.Patches)
+	if before > n {
+		before = n
+	}
+	note.padVersions()
+	keepSet := make(map[int]bool)
+	for _, v := range keep {
+		keepSet[v] = true
+	}
+
+	var (
+		patches  []string
+		versions []Version
+		kept     []string // 保留下来的各版本的内容，用于检查
+		contents string
+		previous string
+		last     int
+	)
+	renumber = make(map[int]int)
+	for v := 1; v <= n; v++ {
+		if contents, err = PatchApply(note.Patches[v-1], contents); err != nil {
+			return nil, fmt.Errorf("version %d: %w", v, err)
+		}
+		if v < before && !keepSet[v] {
+			continue
+		}
+		patch := note.Patches[v-1]
+		if last != v-1 {
+			patch = MakePatch(previous, contents)
+		}
+		version := note.Versions[v-1]
+		version.Size = len(patch)
+		patches = append(patches, patch)
+		versions = append(versions, version)
+		kept = append(kept, contents)
+		renumber[v] = len(patches)
+		previous, last = contents, v
+	}
+	if err := checkPatches(patches, kept); err != nil {
+		return nil, err
+	}
+
+	note.Patches, note.Versions = patches, versions
+	note.Size = 0
+	for _, patch := range patches {
+		note.Size += len(patch)
+	}
+	var milestones []Milestone
+	for _, milestone := range note.Milestones {
+		if v, ok := renumber[milestone.Version]; ok {
+			milestone.Version = v
+			milestones = append(milestones, milestone)
+		}
+	}
+	note.Milestones = milestones
+	return renumber, nil
+}
+
+// checkPatches 检查 patches 是否能够逐一还原出 contents 中的各个版本。
+func checkPatches(patches, contents []string) (err error) {
+	var text string
+	for i, patch := range patches {
+		if text, err = PatchApply(patch, text); err != nil {
+			return fmt.Errorf("compacted version %d: %w", i+1, err)
+		}
+		if text != contents[i] {
+			return fmt.Errorf("compacted version %d: contents mismatch", i+1)
+		}
+	}
+	return nil
+}
+
+// FirstVersionSince 返回第一个创建时间不早于 t 的版本号，
+// 没有记录时间的旧版本视为早于 t. 如果全部版本都早于 t, 则返回 len(note.Patches)+1.
+func (note *Note) FirstVersionSince(t time.Time) int {
+	for i, version := range note.History() {
+		created, err := ParseTime(version.CreatedAt)
+		if err == nil && !created.Before(t) {
+			return i + 1
+		}
+	}
+	return len(note.Patches) + 1
+}
+
+// RetainedVersions 根据保留策略返回应保留的版本号（最新版本等必须保留的版本除外）：
+// now 之前 allDays 天内的版本全部保留，dailyDays 天内每天保留最后一个版本，
+// 更早的版本每月保留最后一个。没有记录时间的旧版本使用 PatchTime 估算时间。
+func (note *Note) RetainedVersions(now time.Time, allDays, dailyDays int) (versions []int) {
+	allSince := now.AddDate(0, 0, -allDays)
+	dailySince := now.AddDate(0, 0, -dailyDays)
+	seen := make(map[string]bool)
+	for i := len(note.Patches) - 1; i >= 0; i-- {
+		created, err := ParseTime(note.PatchTime(i))
+		if err != nil || !created.Before(allSince) {
+			versions = append(versions, i+1)
+			continue
+		}
+		period := created.Format("2006-01")
+		if !created.Before(dailySince) {
+			period = created.Format("2006-01-02")
+		}
+		if !seen[period] {
+			seen[period] = true
+			versions = append(versions, i+1)
+		}
+	}
+	return
+}
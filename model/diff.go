@@ -0,0 +1,153 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// 差异的类型
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// reWord 把文字分割为单词、空白与标点，汉字等每个字算一个单词。
+var reWord = regexp.MustCompile(`\p{Han}|\p{Hiragana}|\p{Katakana}|\p{Hangul}|[\p{L}\p{N}_]+|\s+|.`)
+
+// DiffLine 结构化 diff 中的一行。
+type DiffLine struct {
+	Type    string
+	Text    string // 不含行尾的换行符
+	OldLine int    // 在旧版本中的行号 (从 1 开始)，新增的行为 0
+	NewLine int    // 在新版本中的行号 (从 1 开始)，删除的行为 0
+
+	// NoNewline 表示这一行是文件的最后一行，且行尾没有换行符。
+	NoNewline bool `json:",omitempty"`
+}
+
+// DiffHunk 结构化 diff 中的一段修改。
+type DiffHunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []DiffLine
+}
+
+// WordDiff 单词级 diff 中的一段文字。
+type WordDiff struct {
+	Type string
+	Text string
+}
+
+// LineDiff 返回把 a 变为 b 的结构化 diff (与 MakePatch 的内容一致)。
+func LineDiff(a, b string) ([]DiffHunk, error) {
+	hunks, err := ParsePatch(MakePatch(a, b))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]DiffHunk, len(hunks))
+	for i, hunk := range hunks {
+		result[i] = DiffHunk{
+			OldStart: hunk.OldStart,
+			OldLines: hunk.OldLines,
+			NewStart: hunk.NewStart,
+			NewLines: hunk.NewLines,
+			Lines:    diffLines(hunk),
+		}
+	}
+	return result, nil
+}
+
+func diffLines(hunk Hunk) (lines []DiffLine) {
+	oldLine, newLine := hunk.OldStart, hunk.NewStart
+	if hunk.OldLines == 0 {
+		oldLine++
+	}
+	if hunk.NewLines == 0 {
+		newLine++
+	}
+	for _, line := range hunk.Lines {
+		text := line[1:]
+		switch line[0] {
+		case ' ':
+			lines = append(lines, DiffLine{Type: DiffEqual, Text: text, OldLine: oldLine, NewLine: newLine})
+			oldLine++
+			newLine++
+		case '-':
+			lines = append(lines, DiffLine{Type: DiffDelete, Text: text, OldLine: oldLine})
+			oldLine++
+		case '+':
+			lines = append(lines, DiffLine{Type: DiffInsert, Text: text, NewLine: newLine})
+			newLine++
+		case '\\':
+			if n := len(lines); n > 0 {
+				lines[n-1].NoNewline = true
+			}
+		}
+	}
+	return
+}
+
+// WordDiffs 返回把 a 变为 b 的单词级 diff.
+// 先把每个单词映射为一个字符，再用 diffmatchpatch 比较 (与 DiffLinesToRunes 的做法相同)。
+func WordDiffs(a, b string) []WordDiff {
+	var words []string
+	index := make(map[string]rune)
+	toRunes := func(s string) []rune {
+		var runes []rune
+		for _, word := range reWord.FindAllString(s, -1) {
+			r, ok := index[word]
+			if !ok {
+				r = wordRune(len(words))
+				index[word] = r
+				words = append(words, word)
+			}
+			runes = append(runes, r)
+		}
+		return runes
+	}
+	runesA, runesB := toRunes(a), toRunes(b)
+
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMainRunes(runesA, runesB, false)
+	diffs = dmp.DiffCleanupSemantic(diffs)
+
+	var result []WordDiff
+	for _, diff := range diffs {
+		var text strings.Builder
+		for _, r := range diff.Text {
+			text.WriteString(words[runeWord(r)])
+		}
+		result = append(result, WordDiff{Type: wordDiffType(diff.Type), Text: text.String()})
+	}
+	return result
+}
+
+// wordRune 把第 i 个单词映射为一个字符，跳过 UTF-16 代理区 (这些字符无法保存在 string 中)。
+func wordRune(i int) rune {
+	if i >= 0xD800 {
+		i += 0x800
+	}
+	return rune(i)
+}
+
+func runeWord(r rune) int {
+	if r >= 0xE000 {
+		r -= 0x800
+	}
+	return int(r)
+}
+
+func wordDiffType(op diffmatchpatch.Operation) string {
+	switch op {
+	case diffmatchpatch.DiffInsert:
+		return DiffInsert
+	case diffmatchpatch.DiffDelete:
+		return DiffDelete
+	}
+	return DiffEqual
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"crypto/rand"
+	"encoding/hex"
 	"math/big"
 	"strconv"
 	"time"
@@ -79,3 +80,12 @@ func NextTimeID() string {
 	time.Sleep(100 * time.Microsecond)
 	return TimeID()
 }
+
+// SecureToken 返回一个不可猜测的随机字符串 (128 bits)，用于分享链接等。
+func SecureToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+)
+
+// reWikiLink 匹配 [[id]], [[标题]] 以及带别名的 [[标题|显示的文字]].
+var reWikiLink = regexp.MustCompile(`\[\[([^\[\]|\n]+)(\|[^\[\]\n]*)?\]\]`)
+
+// NoteLink 笔记之间的链接，每次笔记内容变化时根据最新内容重新生成。
+// 链接中的文字 (id 或标题) 不保存，需要时从笔记内容中解析，以免泄露加密的标题。
+type NoteLink struct {
+	ID     string // primary key, random
+	From   string `storm:"index"` // 链接所在的笔记
+	To     string `storm:"index"` // 目标笔记，找不到目标时为空字符串
+	Broken bool   `storm:"index"` // 找不到目标笔记，或目标笔记已被删除
+}
+
+// NewNoteLink .
+func NewNoteLink(from, to string, broken bool) *NoteLink {
+	return &NoteLink{
+		ID:     RandomID(),
+		From:   from,
+		To:     to,
+		Broken: broken || to == "",
+	}
+}
+
+// WikiLinks 返回 contents 中全部 [[id]] 或 [[标题]] 链接的目标（除重，保持原来的顺序）。
+func WikiLinks(contents string) (targets []string) {
+	seen := make(map[string]bool)
+	for _, m := range reWikiLink.FindAllStringSubmatch(contents, -1) {
+		target := strings.TrimSpace(m[1])
+		if target == "" || seen[strings.ToLower(target)] {
+			continue
+		}
+		seen[strings.ToLower(target)] = true
+		targets = append(targets, target)
+	}
+	return
+}
+
+// CanBeLinkTarget reports whether the title can be written as [[title]].
+func CanBeLinkTarget(title string) bool {
+	return strings.TrimSpace(title) != "" && !strings.ContainsAny(title, "[]|\n")
+}
+
+// WikiLink 返回 [[target|text]] 链接，text 为空或与 target 相同时返回 [[target]].
+// text 中不能出现在链接中的字符 ([, ], 换行) 会被替换为空格。
+func WikiLink(target, text string) string {
+	text = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == '[' || r == ']' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, text))
+	if text == "" || text == target {
+		return "[[" + target + "]]"
+	}
+	return "[[" + target + "|" + text + "]]"
+}
+
+// ReplaceWikiLinks 把 contents 中指向 oldTarget (不区分大小写) 的链接改为指向 newTarget,
+// 保留链接的别名部分。
+func ReplaceWikiLinks(contents, oldTarget, newTarget string) string {
+	return reWikiLink.ReplaceAllStringFunc(contents, func(link string) string {
+		m := reWikiLink.FindStringSubmatch(link)
+		if !strings.EqualFold(strings.TrimSpace(m[1]), oldTarget) {
+			return link
+		}
+		return "[[" + newTarget + m[2] + "]]"
+	})
+}
@@ -1,8 +1,10 @@
 package model
 
 import (
+	"encoding/base64"
 	"errors"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 	"unicode/utf8"
@@ -23,29 +25,69 @@ type NoteType string
 const (
 	Plaintext NoteType = "Plaintext"
 	Markdown  NoteType = "Markdown"
+
+	// Encrypted 表示由客户端加密的笔记，其 patches 是服务器无法解密的密文，
+	// 格式见 ClientEncryption.
+	Encrypted NoteType = "Encrypted"
 )
 
 // NewNoteType .
 func NewNoteType(noteType string) NoteType {
-	if strings.ToLower(noteType) == "markdown" {
+	switch strings.ToLower(noteType) {
+	case "markdown":
 		return Markdown
+	case "encrypted":
+		return Encrypted
 	}
 	return Plaintext
 }
 
+// EncryptionScheme 描述客户端加密笔记的格式，以便不同的客户端可以互相兼容。
+// 服务器只负责原样保存，从不解密。
+type EncryptionScheme struct {
+	Version       int
+	Cipher        string
+	KDF           string
+	KDFHash       string
+	KDFIterations int
+	SaltBytes     int
+	NonceBytes    int
+	PatchFormat   string
+	TitleFormat   string
+}
+
+// ClientEncryption 是当前的客户端加密方案。
+var ClientEncryption = EncryptionScheme{
+	Version:       1,
+	Cipher:        "AES-256-GCM",
+	KDF:           "PBKDF2",
+	KDFHash:       "SHA-256",
+	KDFIterations: 200_000,
+	SaltBytes:     16,
+	NonceBytes:    12,
+	PatchFormat: "每个 patch 是 base64(salt || nonce || ciphertext), " +
+		"其明文是相对于上一版本明文的 unified diff (与普通笔记的 patch 相同)",
+	TitleFormat: "由客户端自行决定（可以是密文或简短说明），服务器原样保存",
+}
+
 // Note 表示一个数据表。
 type Note struct {
-	ID        string // primary key
-	Type      NoteType
-	Title     string
-	Contents  string // 历史版本系统升级后，Contents 已被废除，保留只是为了升级过渡。
-	Patches   []string
-	Size      int
-	Tags      []string // []Tag.Name
-	Deleted   bool
-	RemindAt  string `storm:"index"`
-	CreatedAt string `storm:"index"` // ISO8601
-	UpdatedAt string `storm:"index"`
+	ID          string // primary key
+	Type        NoteType
+	Title       string
+	Contents    string // 历史版本系统升级后，Contents 已被废除，保留只是为了升级过渡。
+	Patches     []string
+	Versions    []Version   // 与 Patches 一一对应，记录每个 patch 的元数据
+	Milestones  []Milestone // 按版本号排序
+	ForkOf      string      // 从哪篇笔记复制而来 (id), 空字符串表示不是复制出来的
+	ForkVersion int         // 复制自 ForkOf 的第几个版本
+	Forks       []string    // 从本笔记复制出去的笔记的 id
+	Size        int
+	Tags        []string // []Tag.Name
+	Deleted     bool
+	RemindAt    string `storm:"index"`
+	CreatedAt   string `storm:"index"` // ISO8601
+	UpdatedAt   string `storm:"index"`
 }
 
 // NewNote .
@@ -76,14 +118,106 @@ func (note *Note) AddPatchSetTitle(patch, contents string) error {
 
 // AddPatch 填充内容，同时设置 size。
 // 请总是使用 AddPatch 而不要直接操作 note.Patches, 以确保体积和标题正确。
+// 客户端加密的笔记的 patch 是密文，只检查其格式 (详见 ClientEncryption)。
 func (note *Note) AddPatch(patch string) error {
+	if note.IsEncrypted() {
+		if err := checkEncryptedPatch(patch); err != nil {
+			return err
+		}
+	}
 	if err := note.resetSize(patch); err != nil {
 		return err
 	}
+	note.padVersions()
 	note.Patches = append(note.Patches, patch)
+	note.Versions = append(note.Versions, Version{CreatedAt: TimeNow(), Size: len(patch)})
 	return nil
 }
 
+// Version 一个历史版本 (即一个 patch) 的元数据。
+type Version struct {
+	CreatedAt string // ISO8601, 升级前的旧 patch 没有记录时间，因此为空字符串
+	Size      int    // patch 的体积
+	Session   string // 创建该版本的会话 (会话 ID 的 hash, 详见 DB.SessionLabel)
+}
+
+// padVersions 为没有记录元数据的旧 patch 补上元数据 (时间未知)。
+func (note *Note) padVersions() {
+	for i := len(note.Versions); i < len(note.Patches); i++ {
+		note.Versions = append(note.Versions, Version{Size: len(note.Patches[i])})
+	}
+}
+
+// History 返回全部历史版本的元数据，第 i 个元素对应第 i+1 个版本。
+func (note *Note) History() []Version {
+	note.padVersions()
+	return note.Versions[:len(note.Patches)]
+}
+
+// SetVersionSession 记录最新的版本是由哪个会话创建的。
+func (note *Note) SetVersionSession(session string) {
+	note.padVersions()
+	if n := len(note.Versions); n > 0 {
+		note.Versions[n-1].Session = session
+	}
+}
+
+// Milestone 里程碑，用于给某个历史版本加上名称。
+// 受保护的里程碑在压缩历史时会被保留，确保该版本仍然可以重建。
+type Milestone struct {
+	Version   int // 第几个版本，从 1 开始
+	Label     string
+	Protected bool
+	CreatedAt string // ISO8601
+}
+
+// NewMilestone .
+func NewMilestone(version int, label string, protected bool) Milestone {
+	return Milestone{
+		Version:   version,
+		Label:     firstLineLimit(label, config.NoteTitleLimit),
+		Protected: protected,
+		CreatedAt: TimeNow(),
+	}
+}
+
+// SetMilestone 添加里程碑，如果该版本已有里程碑则替换之。
+func (note *Note) SetMilestone(milestone Milestone) error {
+	if milestone.Version < 1 || milestone.Version > len(note.Patches) {
+		return errors.New("version out of range")
+	}
+	if milestone.Label == "" {
+		return errors.New("label is empty")
+	}
+	note.DeleteMilestone(milestone.Version)
+	note.Milestones = append(note.Milestones, milestone)
+	sort.Slice(note.Milestones, func(i, j int) bool {
+		return note.Milestones[i].Version < note.Milestones[j].Version
+	})
+	return nil
+}
+
+// DeleteMilestone 删除指定版本的里程碑，如果该版本没有里程碑则返回 false.
+func (note *Note) DeleteMilestone(version int) bool {
+	for i := range note.Milestones {
+		if note.Milestones[i].Version == version {
+			note.Milestones = append(note.Milestones[:i], note.Milestones[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ProtectedVersions 返回受保护的里程碑的版本号（从小到大）。
+func (note *Note) ProtectedVersions() (versions []int) {
+	for _, milestone := range note.Milestones {
+		if milestone.Protected {
+			versions = append(versions, milestone.Version)
+		}
+	}
+	return
+}
+
 func (note *Note) resetSize(patch string) error {
 	size := note.Size + len(patch)
 	if size > config.NoteSizeLimit {
@@ -93,8 +227,34 @@ func (note *Note) resetSize(patch string) error {
 	return nil
 }
 
+// IsEncrypted reports whether the note is encrypted by the client.
+func (note *Note) IsEncrypted() bool {
+	return note.Type == Encrypted
+}
+
+// checkEncryptedPatch 检查客户端加密的 patch 是否为 base64(salt || nonce || ciphertext),
+// 其中 ciphertext 至少包括 AES-GCM 的 16 bytes 认证标签。
+func checkEncryptedPatch(patch string) error {
+	blob, err := base64.StdEncoding.DecodeString(patch)
+	if err != nil {
+		return errors.New("encrypted patch is not base64")
+	}
+	if len(blob) < ClientEncryption.SaltBytes+ClientEncryption.NonceBytes+16 {
+		return errors.New("encrypted patch is too short")
+	}
+	return nil
+}
+
 // SetTitle 设置限定长度的标题，其中 contents 必须事先 TrimSpace 并确保不是空字串。
+// 客户端加密的笔记不提取标题，而是原样保存客户端提供的标题（只限制长度）。
 func (note *Note) SetTitle(contents string) {
+	if note.IsEncrypted() {
+		if len(contents) > config.NoteTitleLimit {
+			contents = contents[:config.NoteTitleLimit]
+		}
+		note.Title = contents
+		return
+	}
 	title := firstLineLimit(contents, config.NoteTitleLimit)
 	if note.Type == Markdown {
 		if mdTitle := getMarkdownTitle(title); mdTitle != "" {
@@ -104,20 +264,19 @@ func (note *Note) SetTitle(contents string) {
 	note.Title = title
 }
 
-func diffGNU(s string) string {
-	i := strings.Index(s, "@@")
-	re := regexp.MustCompile(`\\ .*`)
-	return re.ReplaceAllString(s[i:], "")
-}
-
-func patchApply(patch string, text string) (string, error) {
-	dmp := diffmatchpatch.New()
-	patches, err := dmp.PatchFromText(diffGNU(patch))
+// PatchApply 把 patch (由前端 jsdiff 或 MakePatch 生成的 unified diff) 应用到 text 上。
+// 与前端 jsdiff 的 applyPatch 一样，要求上下文完全吻合，否则返回错误。
+// 注意 diffmatchpatch 的 patch 按字符计算位置，不能用来应用按行计算的 unified diff.
+func PatchApply(patch string, text string) (string, error) {
+	hunks, err := ParsePatch(patch)
+	if err != nil {
+		return "", err
+	}
+	lines, err := applyHunks(splitLines(text), hunks)
 	if err != nil {
 		return "", err
 	}
-	patched, _ := dmp.PatchApply(patches, text)
-	return patched, nil
+	return strings.Join(lines, ""), nil
 }
 
 // UpdatedAtNow updates note.UpdatedAt to TimeNow().
@@ -128,18 +287,26 @@ func (note *Note) UpdatedAtNow() {
 // SetTags 对标签进行一些验证和处理（例如除重和排序）。
 // 尽量不要直接操作 note.Tags
 func (note *Note) SetTags(tags []string) error {
-	sorted := stringset.UniqueSort(tags)
+	sorted := stringset.UniqueSort(purify(tags))
 	if len(sorted) < 2 {
 		return errors.New("too few tags (at least two)")
 	}
-	note.Tags = purify(sorted)
+	note.Tags = sorted
 	return nil
 }
 
+var reTagChars = regexp.MustCompile(`[#;,，'"\+\n]`)
+
+// PurifyTag 删除标签中的特殊字符，并规范化父子标签的写法 (详见 NormalizeTag)。
+func PurifyTag(name string) string {
+	return NormalizeTag(reTagChars.ReplaceAllString(name, ""))
+}
+
 func purify(tags []string) (purified []string) {
-	re := regexp.MustCompile(`[#;,，'"/\+\n]`)
 	for i := range tags {
-		purified = append(purified, re.ReplaceAllString(tags[i], ""))
+		if tag := PurifyTag(tags[i]); tag != "" {
+			purified = append(purified, tag)
+		}
 	}
 	return
 }
@@ -217,6 +384,13 @@ func TimeNow() string {
 	return time.Now().Format(config.ISO8601)
 }
 
+// ParseTime 解析由 TimeNow 生成的时间字符串。
+// config.ISO8601 中的时区是固定的文字，因此必须按服务器的本地时区解析，
+// 才能与请求中的时间 (time.Local) 正确比较。
+func ParseTime(s string) (time.Time, error) {
+	return time.ParseInLocation(config.ISO8601, s, time.Local)
+}
+
 // firstLineLimit 返回第一行，并限定长度，其中 s 必须事先 TrimSpace 并确保不是空字串。
 // 该函数会尽量确保最后一个字符是有效的 utf8 字符，但当第一行中的全部字符都无效时，
 // 则按原样返回每一行。
@@ -276,3 +450,99 @@ func (group *TagGroup) RenameTag(oldName, newName string) {
 	}
 	group.Tags = stringset.AddAndDelete(group.Tags, oldName, newName)
 }
+
+// TwoFactor 保存两步验证 (TOTP) 的状态，保存在 metadata bucket 中。
+type TwoFactor struct {
+	Secret        string
+	Enabled       bool     // 用户输入正确的验证码确认后才正式启用
+	RecoveryCodes []string // 只保存恢复码的哈希值，每个恢复码只能使用一次
+	LastCounter   int64    // 最近一次通过验证的时间窗口，防止同一验证码被重复使用
+	CreatedAt     string
+}
+
+// 审计日志的操作类型
+const (
+	OpLogin            = "login"
+	OpNoteCreate       = "note.create"
+	OpNotePatch        = "note.patch"
+	OpNoteType         = "note.type"
+	OpNoteTags         = "note.tags"
+	OpNoteDeleted      = "note.deleted"
+	OpNoteDelete       = "note.delete-forever"
+	OpNoteCompact      = "note.history.compact"
+	OpNoteRetention    = "note.history.retention"
+	OpNoteRestore      = "note.restore"
+	OpNoteFork         = "note.fork"
+	OpMilestoneAdd     = "milestone.add"
+	OpMilestoneDelete  = "milestone.delete"
+	OpTagRename        = "tag.rename"
+	OpTagDelete        = "tag.delete"
+	OpTagMerge         = "tag.merge"
+	OpTagAliasAdd      = "tag.alias.add"
+	OpTagAliasDelete   = "tag.alias.delete"
+	OpTagGroupAdd      = "taggroup.add"
+	OpTagGroupDelete   = "taggroup.delete"
+	OpTagGroupProtect  = "taggroup.protected"
+	OpBackupExport     = "backup.export"
+	OpBackupArchive    = "backup.archive"
+	OpBackupGit        = "backup.git"
+	OpBackupResetTags  = "backup.reset-all-tags"
+	OpBackupLinks      = "backup.rebuild-links"
+	OpBackupImport     = "backup.import-notes"
+	OpTwoFactorEnroll  = "totp.enroll"
+	OpTwoFactorConfirm = "totp.confirm"
+	OpTwoFactorDisable = "totp.disable"
+	OpShareAdd         = "share.add"
+	OpShareDelete      = "share.delete"
+)
+
+// AuditEntry 审计日志，每次修改操作产生一条，只增不改。
+type AuditEntry struct {
+	ID        string   // primary key, TimeID
+	Time      string   `storm:"index"` // ISO8601
+	Session   string   // 会话的标识 (会话 ID 的 hash, 详见 DB.SessionLabel)
+	IP        string   // client IP
+	Operation string   `storm:"index"`
+	Targets   []string // 被修改的对象的 ID (或标签名称)
+	Before    string   // 修改前的简要描述
+	After     string   // 修改后的简要描述
+}
+
+// NewAuditEntry .
+func NewAuditEntry(op string, targets []string, before, after string) *AuditEntry {
+	return &AuditEntry{
+		ID:        NextTimeID(),
+		Time:      TimeNow(),
+		Operation: op,
+		Targets:   targets,
+		Before:    before,
+		After:     after,
+	}
+}
+
+// Share 笔记的只读分享链接，任何人都可以通过 /s/:token 访问，不需要登入。
+type Share struct {
+	Token        string `storm:"id"` // 随机生成，不可猜测
+	NoteID       string `storm:"index"`
+	Version      int    // 固定显示某个历史版本，0 表示总是显示最新版本
+	ExpiresAt    string // ISO8601, 空字符串表示永不过期
+	AccessCount  int
+	LastAccessAt string
+	CreatedAt    string `storm:"index"`
+}
+
+// NewShare .
+func NewShare(noteID string, version int, expiresAt string) *Share {
+	return &Share{
+		Token:     SecureToken(),
+		NoteID:    noteID,
+		Version:   version,
+		ExpiresAt: expiresAt,
+		CreatedAt: TimeNow(),
+	}
+}
+
+// Expired reports whether the share link is expired.
+func (share *Share) Expired() bool {
+	return share.ExpiresAt != "" && share.ExpiresAt < TimeNow()
+}
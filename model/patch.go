@@ -0,0 +1,203 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ianbruene/go-difflib/difflib"
+)
+
+var reHunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// Hunk 是 unified diff 中的一段修改。
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+
+	// Lines 中的每一行都以 ' ', '-', '+' 或 '\' 开头，不含行尾的换行符。
+	Lines []string
+}
+
+// ParsePatch 解析由前端 jsdiff (createPatch) 或 difflib 生成的 unified diff.
+// 第一个 "@@" 之前的内容 (Index, ---, +++ 等) 会被忽略。
+func ParsePatch(patch string) (hunks []Hunk, err error) {
+	lines := strings.Split(patch, "\n")
+	i := 0
+	for i < len(lines) && !strings.HasPrefix(lines[i], "@@") {
+		i++
+	}
+	for i < len(lines) {
+		if lines[i] == "" && i == len(lines)-1 {
+			break
+		}
+		var hunk Hunk
+		if hunk, err = parseHunkHeader(lines[i]); err != nil {
+			return nil, err
+		}
+		i++
+		oldCount, newCount := 0, 0
+		for i < len(lines) && (oldCount < hunk.OldLines || newCount < hunk.NewLines ||
+			strings.HasPrefix(lines[i], `\`)) {
+			line := lines[i]
+			if line == "" {
+				return nil, fmt.Errorf("patch line %d: empty line in hunk", i+1)
+			}
+			switch line[0] {
+			case ' ':
+				oldCount++
+				newCount++
+			case '-':
+				oldCount++
+			case '+':
+				newCount++
+			case '\\':
+			default:
+				return nil, fmt.Errorf("patch line %d: unknown line type", i+1)
+			}
+			hunk.Lines = append(hunk.Lines, line)
+			i++
+		}
+		if oldCount != hunk.OldLines || newCount != hunk.NewLines {
+			return nil, errors.New("patch: hunk line count mismatch")
+		}
+		hunks = append(hunks, hunk)
+	}
+	return hunks, nil
+}
+
+func parseHunkHeader(line string) (hunk Hunk, err error) {
+	m := reHunkHeader.FindStringSubmatch(line)
+	if m == nil {
+		return hunk, fmt.Errorf("patch: bad hunk header [%s]", line)
+	}
+	atoi := func(s string) int {
+		if s == "" {
+			return 1 // 省略行数时表示 1 行
+		}
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	hunk.OldStart, hunk.OldLines = atoi(m[1]), atoi(m[2])
+	hunk.NewStart, hunk.NewLines = atoi(m[3]), atoi(m[4])
+	return hunk, nil
+}
+
+// splitLines 把 s 分割为多行，每行保留行尾的换行符（最后一行可能没有换行符）。
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func applyHunks(old []string, hunks []Hunk) (result []string, err error) {
+	pos := 0 // 下一个尚未处理的旧行
+	for _, hunk := range hunks {
+		start := hunk.OldStart - 1
+		if hunk.OldLines == 0 {
+			start = hunk.OldStart // 纯插入时，OldStart 表示插入到该行之后
+		}
+		if start < pos || start > len(old) {
+			return nil, errors.New("patch: hunk out of range")
+		}
+		result = append(result, old[pos:start]...)
+		pos = start
+
+		var prev byte
+		for _, line := range hunk.Lines {
+			switch line[0] {
+			case ' ', '-':
+				if pos >= len(old) || strings.TrimSuffix(old[pos], "\n") != line[1:] {
+					return nil, errors.New("patch: context mismatch")
+				}
+				if line[0] == ' ' {
+					result = append(result, old[pos])
+				}
+				pos++
+			case '+':
+				result = append(result, line[1:]+"\n")
+			case '\\':
+				// "\ No newline at end of file" 只影响新增的行，上下文行直接沿用旧行。
+				if prev == '+' {
+					last := len(result) - 1
+					result[last] = strings.TrimSuffix(result[last], "\n")
+				}
+			}
+			prev = line[0]
+		}
+	}
+	return append(result, old[pos:]...), nil
+}
+
+// UnifiedDiff 使用 difflib 生成把 a 变为 b 的 unified diff (a, b 中的每一行都以换行符结尾)。
+func UnifiedDiff(a, b []string) (string, error) {
+	diff := difflib.LineDiffParams{
+		A:        a,
+		B:        b,
+		FromFile: " ",
+		ToFile:   " ",
+	}
+	return difflib.GetUnifiedDiffString(diff)
+}
+
+// MakePatch 生成把 a 变为 b 的 unified diff, 可被前端 jsdiff 应用
+// (没有换行符的最后一行会带上 "\ No newline at end of file")。
+// 内容相同时返回空字符串。
+func MakePatch(a, b string) string {
+	// 写入 strings.Builder 不会出错，因此可忽略 err.
+	patch, _ := UnifiedDiff(patchLines(a), patchLines(b))
+	return patch
+}
+
+// patchLines 把 s 分割为多行，如果最后一行没有换行符，则为它加上
+// "\ No newline at end of file" 标记，使它与有换行符的同一行不相等。
+func patchLines(s string) []string {
+	lines := splitLines(s)
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		lines[n-1] += "\n\\ No newline at end of file\n"
+	}
+	return lines
+}
+
+// ContentsAt 返回第 n 个版本的内容（n 从 1 开始，0 表示空内容）。
+// 客户端加密的笔记无法在服务器端还原内容。
+func (note *Note) ContentsAt(n int) (contents string, err error) {
+	if note.IsEncrypted() {
+		return "", errors.New("cannot reconstruct an encrypted note")
+	}
+	if n < 0 || n > len(note.Patches) {
+		return "", fmt.Errorf("version %d out of range [0, %d]", n, len(note.Patches))
+	}
+	for i := 0; i < n; i++ {
+		if contents, err = PatchApply(note.Patches[i], contents); err != nil {
+			return "", fmt.Errorf("version %d: %w", i+1, err)
+		}
+	}
+	return contents, nil
+}
+
+// PatchTime 返回第 i 个 patch (从 0 开始) 的创建时间 (ISO8601)。
+// 升级前的旧 patch 没有记录时间，此时第一个 patch 使用 CreatedAt, 其余使用 UpdatedAt.
+func (note *Note) PatchTime(i int) string {
+	if i < len(note.Versions) && note.Versions[i].CreatedAt != "" {
+		return note.Versions[i].CreatedAt
+	}
+	if i == 0 {
+		return note.CreatedAt
+	}
+	return note.UpdatedAt
+}
+
+// CurrentContents 返回最新版本的内容。
+func (note *Note) CurrentContents() (string, error) {
+	return note.ContentsAt(len(note.Patches))
+}
@@ -0,0 +1,144 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+// 以下 patch 由前端 jsdiff 的 createPatch(" ", old, new) 生成。
+const jsdiffHeader = "Index: \n" +
+	"===================================================================\n" +
+	"--- \t\n+++ \t\n"
+
+var jsdiffTests = []struct {
+	name, old, new, patch string
+}{
+	{
+		name:  "insert into empty text without final newline",
+		old:   "",
+		new:   "one\ntwo",
+		patch: jsdiffHeader + "@@ -0,0 +1,2 @@\n+one\n+two\n\\ No newline at end of file\n",
+	},
+	{
+		name:  "remove final newline",
+		old:   "a\nb\n",
+		new:   "a\nb",
+		patch: jsdiffHeader + "@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of file\n",
+	},
+	{
+		name: "two hunks at both ends",
+		old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+		new:  "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\nX\n",
+		patch: jsdiffHeader + "@@ -1,4 +1,5 @@\n+0\n 1\n 2\n 3\n 4\n" +
+			"@@ -6,5 +7,5 @@\n 6\n 7\n 8\n 9\n-10\n+X\n",
+	},
+}
+
+func TestPatchApplyJsdiff(t *testing.T) {
+	for _, tt := range jsdiffTests {
+		got, err := PatchApply(tt.patch, tt.old)
+		if err != nil || got != tt.new {
+			t.Errorf("%s: PatchApply = %q, %v, want %q", tt.name, got, err, tt.new)
+		}
+	}
+}
+
+func TestPatchApplyMakePatch(t *testing.T) {
+	texts := []string{"", "a", "a\n", "a\nb", "a\nb\n", "b\na\n", "x\n\ny\n", "a\nb\nc\nd\ne\nf\ng\nh\ni\n"}
+	for _, a := range texts {
+		for _, b := range texts {
+			got, err := PatchApply(MakePatch(a, b), a)
+			if err != nil || got != b {
+				t.Errorf("PatchApply(MakePatch(%q, %q)) = %q, %v", a, b, got, err)
+			}
+		}
+	}
+}
+
+func TestPatchApplyDeleteAll(t *testing.T) {
+	patch := "@@ -1,2 +0,0 @@\n-a\n-b\n\\ No newline at end of file\n"
+	got, err := PatchApply(patch, "a\nb")
+	if err != nil || got != "" {
+		t.Errorf("PatchApply(delete all) = %q, %v", got, err)
+	}
+}
+
+func TestPatchApplyMismatch(t *testing.T) {
+	tests := []struct {
+		name, patch, text string
+	}{
+		{"context mismatch", "@@ -1,2 +1,2 @@\n a\n-b\n+c\n", "a\nx\n"},
+		{"beyond the end", "@@ -3,1 +3,1 @@\n-c\n+d\n", "a\n"},
+		{"overlapping hunks", "@@ -2,1 +2,1 @@\n-b\n+B\n@@ -1,1 +1,1 @@\n-a\n+A\n", "a\nb\n"},
+	}
+	for _, tt := range tests {
+		if _, err := PatchApply(tt.patch, tt.text); err == nil {
+			t.Errorf("%s: PatchApply should fail", tt.name)
+		}
+	}
+}
+
+func TestParsePatch(t *testing.T) {
+	hunks, err := ParsePatch(jsdiffTests[2].patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hunks) != 2 {
+		t.Fatalf("len(hunks) = %d, want 2", len(hunks))
+	}
+	h := hunks[1]
+	if h.OldStart != 6 || h.OldLines != 5 || h.NewStart != 7 || h.NewLines != 5 || len(h.Lines) != 6 {
+		t.Errorf("hunks[1] = %+v", h)
+	}
+
+	// 省略行数时表示 1 行；"\ No newline" 不计入行数。
+	hunks, err = ParsePatch("@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+b\n\\ No newline at end of file\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := hunks[0]; h.OldLines != 1 || h.NewLines != 1 || len(h.Lines) != 4 {
+		t.Errorf("hunk = %+v", h)
+	}
+
+	if hunks, err := ParsePatch(jsdiffHeader); err != nil || len(hunks) != 0 {
+		t.Errorf("ParsePatch(header only) = %v, %v", hunks, err)
+	}
+}
+
+func TestParsePatchErrors(t *testing.T) {
+	tests := []struct {
+		name, patch, want string
+	}{
+		{"bad header", "@@ -a +b @@\n", "bad hunk header"},
+		{"too few lines", "@@ -1,2 +1,2 @@\n a", "line count mismatch"},
+		{"too many lines", "@@ -1,1 +1,1 @@\n a\n b\n", "bad hunk header"},
+		{"unknown line type", "@@ -1,1 +1,1 @@\n*a\n", "unknown line type"},
+		{"empty line in hunk", "@@ -1,2 +1,2 @@\n a\n\n b\n", "empty line"},
+	}
+	for _, tt := range tests {
+		_, err := ParsePatch(tt.patch)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestAddPatchValidation(t *testing.T) {
+	// 普通笔记的 patch 不检查格式。
+	note := NewNote("1", Markdown)
+	if err := note.AddPatch("not a patch"); err != nil {
+		t.Errorf("AddPatch(plain note) err = %v", err)
+	}
+
+	encrypted := NewNote("2", Encrypted)
+	if err := encrypted.AddPatch("not base64!"); err == nil {
+		t.Error("AddPatch(encrypted note, not base64) should fail")
+	}
+	if err := encrypted.AddPatch("AAAA"); err == nil {
+		t.Error("AddPatch(encrypted note, too short) should fail")
+	}
+	valid := strings.Repeat("A", 60) // 45 bytes
+	if err := encrypted.AddPatch(valid); err != nil {
+		t.Errorf("AddPatch(encrypted note) err = %v", err)
+	}
+}
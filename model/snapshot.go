@@ -0,0 +1,76 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// NoteChange 记录笔记的标签与删除状态的一次变化（保存的是变化后的状态），
+// 用于还原某一时刻的笔记本。
+type NoteChange struct {
+	ID      string // primary key, 基于时间
+	NoteID  string `storm:"index"`
+	Tags    []string
+	Deleted bool
+	Time    string `storm:"index"` // ISO8601
+}
+
+// NewNoteChange 记录 note 当前的标签与删除状态，t 是变化发生的时间 (ISO8601)。
+func NewNoteChange(note *Note, t string) *NoteChange {
+	return &NoteChange{
+		ID:      NextTimeID(),
+		NoteID:  note.ID,
+		Tags:    note.Tags,
+		Deleted: note.Deleted,
+		Time:    t,
+	}
+}
+
+// At 把笔记还原为 t 时刻的内容：只保留在 t 之前创建的历史版本，
+// 并重新设置体积、标题与更新时间（标签与删除状态由 NoteChange 决定）。
+// 如果 t 时刻笔记尚未创建，或该时刻之前的版本都已被压缩，则返回 false.
+func (note *Note) At(t time.Time) (bool, error) {
+	created, err := ParseTime(note.CreatedAt)
+	if err == nil && created.After(t) {
+		return false, nil
+	}
+	n := 0
+	for i := range note.Patches {
+		patchTime, err := ParseTime(note.PatchTime(i))
+		if err != nil || patchTime.After(t) {
+			break
+		}
+		n = i + 1
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	note.UpdatedAt = note.PatchTime(n - 1)
+	note.padVersions()
+	note.Patches, note.Versions = note.Patches[:n], note.Versions[:n]
+	note.Size = 0
+	for _, patch := range note.Patches {
+		note.Size += len(patch)
+	}
+	var milestones []Milestone
+	for _, milestone := range note.Milestones {
+		if milestone.Version <= n {
+			milestones = append(milestones, milestone)
+		}
+	}
+	note.Milestones = milestones
+
+	// 客户端加密的笔记无法还原内容，保留当前的标题。
+	if note.IsEncrypted() {
+		return true, nil
+	}
+	contents, err := note.CurrentContents()
+	if err != nil {
+		return false, err
+	}
+	if contents = strings.TrimSpace(contents); contents != "" {
+		note.SetTitle(contents)
+	}
+	return true, nil
+}
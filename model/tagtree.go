@@ -0,0 +1,113 @@
+package model
+
+import (
+	"sort"
+	"strings"
+)
+
+// TagSep 分隔父标签与子标签，例如 "editor/vim" 是 "editor" 的子标签。
+const TagSep = "/"
+
+// NormalizeTag 去除每一段首尾的空白以及空白的段，
+// 例如 " editor / / vim/" 变为 "editor/vim".
+func NormalizeTag(name string) string {
+	var parts []string
+	for _, part := range strings.Split(name, TagSep) {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, TagSep)
+}
+
+// TagAncestors 返回全部父标签，由近及远，例如 "a/b/c" 返回 ["a/b", "a"].
+func TagAncestors(name string) (ancestors []string) {
+	for i := strings.LastIndex(name, TagSep); i > 0; i = strings.LastIndex(name, TagSep) {
+		name = name[:i]
+		ancestors = append(ancestors, name)
+	}
+	return
+}
+
+// IsTagUnder reports whether name is parent itself or one of its descendants.
+func IsTagUnder(name, parent string) bool {
+	return name == parent || strings.HasPrefix(name, parent+TagSep)
+}
+
+// HasTagUnder reports whether one of the tags is parent itself or one of its descendants.
+func HasTagUnder(tags []string, parent string) bool {
+	for _, tag := range tags {
+		if IsTagUnder(tag, parent) {
+			return true
+		}
+	}
+	return false
+}
+
+// MoveTagName 把 name 从 oldParent 移动到 newParent 之下 (name 必须在 oldParent 之下)，
+// 例如 MoveTagName("editor/vim/plugin", "editor/vim", "vim") 返回 "vim/plugin".
+func MoveTagName(name, oldParent, newParent string) string {
+	return newParent + strings.TrimPrefix(name, oldParent)
+}
+
+// TagNode 标签树中的一个节点。
+// 只作为父标签存在 (没有笔记直接使用) 的节点，其 Count 为零。
+type TagNode struct {
+	Name     string // 最后一段，例如 "vim"
+	Path     string // 完整的标签名称，例如 "editor/vim"
+	Count    int    // 直接使用该标签的笔记数量
+	Total    int    // 使用该标签或其子标签的笔记数量 (除重)
+	Children []*TagNode
+}
+
+// TagTree 根据全部标签生成标签树，同一层的节点按名称排序。
+func TagTree(tags []Tag) []*TagNode {
+	nodes := make(map[string]*TagNode)
+	noteIDs := make(map[string]map[string]bool)
+	var roots []*TagNode
+
+	var getNode func(path string) *TagNode
+	getNode = func(path string) *TagNode {
+		if node, ok := nodes[path]; ok {
+			return node
+		}
+		node := &TagNode{Name: path, Path: path, Children: []*TagNode{}}
+		nodes[path] = node
+		noteIDs[path] = make(map[string]bool)
+		if i := strings.LastIndex(path, TagSep); i > 0 {
+			node.Name = path[i+len(TagSep):]
+			parent := getNode(path[:i])
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+		return node
+	}
+
+	for _, tag := range tags {
+		node := getNode(tag.Name)
+		node.Count = len(tag.NoteIDs)
+		for _, path := range append([]string{tag.Name}, TagAncestors(tag.Name)...) {
+			for _, id := range tag.NoteIDs {
+				noteIDs[path][id] = true
+			}
+		}
+	}
+	for path, node := range nodes {
+		node.Total = len(noteIDs[path])
+	}
+	sortTagNodes(roots)
+	if roots == nil {
+		roots = []*TagNode{}
+	}
+	return roots
+}
+
+func sortTagNodes(nodes []*TagNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+	for _, node := range nodes {
+		sortTagNodes(node.Children)
+	}
+}
@@ -1,5 +1,7 @@
 const loading = $('#loading');
 const pw_input = $('#password');
+const code_input = $('#code');
+const passphrase_input = $('#passphrase');
 const submit_btn = $('#submit');
 const formElem = $('form');
 
@@ -8,6 +10,9 @@ ajaxGet('/check', null, that => {
     insertSuccessAlert('已登入')
     return;
   }
+  if (that.response.passphrase) {
+    $('#passphrase-label').show();
+  }
   formElem.show();
   pw_input.focus();
 }, function() {
@@ -26,6 +31,8 @@ submit_btn.click(event => {
   
   let form = new FormData();
   form.append('password', password);
+  form.append('code', code_input.val().trim());
+  form.append('passphrase', passphrase_input.val());
 
   ajaxPost(form, '/login', submit_btn, function() {
     $('.alert').remove();
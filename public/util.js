@@ -71,9 +71,22 @@ function ajaxDo(method, form, url, btn, onSuccess, onloadend, onFail) {
     xhr.send();
     return;
   }
+  xhr.setRequestHeader('X-CSRF-Token', getCookie('uglynotesCSRF'));
   xhr.send(form);
 }
 
+// 获取 cookie 的值，找不到时返回空字符串。
+function getCookie(name) {
+  const prefix = name + '=';
+  for (const item of document.cookie.split(';')) {
+    const cookie = item.trim();
+    if (cookie.startsWith(prefix)) {
+      return decodeURIComponent(cookie.substring(prefix.length));
+    }
+  }
+  return '';
+}
+
 function ajaxPost(form, url, btn, onSuccess, onloadend) {
   ajaxDo('POST', form, url, btn, onSuccess, onloadend);
 }
@@ -114,7 +127,7 @@ function getTags(tagsElem) {
 }
 
 function tag_replace(tags) {
-  return tags.replace(/[#;,，'"/\+\n]/g, ' ').trim();
+  return tags.replace(/[#;,，'"\+\n]/g, ' ').trim();
 }
 
 // 把集合数组转化为字符串。
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/ahui2016/uglynotes/settings"
+)
+
+// retentionSession 代替会话，表示由后台任务完成的操作。
+const retentionSession = "retention"
+
+// runRetention 按 config.Retention 定期合并历史版本（详见 DB.ApplyRetention），
+// 应在单独的 goroutine 中运行。
+func runRetention() {
+	policy := config.Retention
+	if !policy.Enabled {
+		return
+	}
+	interval, err := time.ParseDuration(policy.Interval)
+	if err != nil || interval <= 0 {
+		log.Printf("retention disabled: invalid interval %q", policy.Interval)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		applyRetention(policy)
+		<-ticker.C
+	}
+}
+
+func applyRetention(policy settings.RetentionPolicy) {
+	db.Lock()
+	defer db.Unlock()
+
+	// 启用了加密但尚未有人登入输入 passphrase, 等下次再试。
+	if db.DataLocked() {
+		return
+	}
+	ids, removed, err := db.ApplyRetention(
+		time.Now(), policy.KeepAllDays, policy.KeepDailyDays)
+	if err != nil {
+		log.Printf("retention: %v", err)
+	}
+	if removed == 0 {
+		return
+	}
+	summary := fmt.Sprintf("removed %d versions", removed)
+	entry := model.NewAuditEntry(model.OpNoteRetention, ids, "", summary)
+	entry.Session = retentionSession
+	if err := db.AddAudit(entry); err != nil {
+		log.Print(err)
+	}
+	log.Printf("retention: %s from %d notes", summary, len(ids))
+}
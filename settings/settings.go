@@ -37,6 +37,39 @@ type Settings struct {
 	// TagGroupLimit 限制标签组数量上限。
 	// 当超过上限时，不受保护的标签组会被覆盖。可通过点击 "protect" 按钮保护标签。
 	TagGroupLimit int
+
+	// Require2FA 要求必须启用两步验证 (TOTP)。
+	// 设为 true 后，未通过两步验证的会话只能访问 /api/totp 相关接口（用于设置两步验证）。
+	Require2FA bool
+
+	// EncryptAtRest 加密保存笔记的标题与内容 (AES-256-GCM)。
+	// 密钥由登入时输入的 passphrase 推导而来，只保存在内存中，从不写入硬盘，
+	// 因此服务器重启后，需要有人登入一次（输入 passphrase）才能读取笔记。
+	// 可使用 -rotate-key 参数更换 passphrase.
+	// 从 true 改为 false 后，下次登入时会解密全部笔记。
+	// 注意：对已有数据启用加密后，数据库文件的空闲页中可能仍残留旧的明文，
+	// 需要时可使用 bbolt compact 命令压缩数据库文件以清除残留。
+	EncryptAtRest bool
+
+	// ImportTagGroup 导入笔记时，如果一篇笔记的标签少于两个，则添加这组标签。
+	// 设为空列表则不添加，此时标签不足的笔记会被跳过。
+	ImportTagGroup []string
+
+	// Retention 历史版本的自动保留策略，默认关闭。
+	// 启用后，后台任务每隔 Interval 合并一次相邻的历史版本，以免笔记体积超过上限：
+	// 最近 KeepAllDays 天内的版本全部保留，最近 KeepDailyDays 天内每天保留最后一个版本，
+	// 更早的版本每月保留最后一个。受保护的里程碑、分享链接固定的版本以及最新版本总是保留。
+	Retention RetentionPolicy
+}
+
+// RetentionPolicy 历史版本的保留策略，详见 Settings.Retention.
+type RetentionPolicy struct {
+	Enabled       bool
+	KeepAllDays   int
+	KeepDailyDays int
+
+	// 有效单位是 "s", "m", "h"
+	Interval string
 }
 
 var Config = Default()
@@ -56,5 +89,11 @@ func Default() Settings {
 		DatabaseCapacity: 1 << 20 * 10, // 10MB
 		ISO8601:          "2006-01-02T15:04:05.999+00:00",
 		TagGroupLimit:    100,
+		ImportTagGroup:   []string{"imported", "inbox"},
+		Retention: RetentionPolicy{
+			KeepAllDays:   7,
+			KeepDailyDays: 90,
+			Interval:      "24h",
+		},
 	}
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahui2016/uglynotes/database"
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/ahui2016/uglynotes/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+var shareTmpl = template.Must(template.New("share").Parse(`<!doctype html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <meta name="robots" content="noindex">
+    <link rel="stylesheet" href="/public/style.css">
+    <title>{{.Title}} .. uglynotes</title>
+  </head>
+  <body>
+    <p>uglynotes .. shared note{{if .Version}} (version {{.Version}}){{end}}
+      .. <a href="/s/{{.Token}}/raw">raw</a></p>
+    <hr>
+    <div id="contents"><pre style="white-space: pre-wrap;">{{.Contents}}</pre></div>
+    {{if .Markdown}}
+    <script src="https://cdn.jsdelivr.net/npm/marked@1.2.7/lib/marked.min.js"></script>
+    <script src="https://cdn.jsdelivr.net/npm/dompurify@2.2.6/dist/purify.min.js"></script>
+    <script>
+      const elem = document.getElementById('contents');
+      elem.innerHTML = DOMPurify.sanitize(marked(elem.textContent));
+    </script>
+    {{end}}
+  </body>
+</html>
+`))
+
+// getExpiresAt 把表单中的 expires (例如 "72h") 转换为过期时间，为空表示永不过期。
+func getExpiresAt(c *fiber.Ctx) (string, error) {
+	expires := strings.TrimSpace(c.FormValue("expires"))
+	if expires == "" {
+		return "", nil
+	}
+	d, err := time.ParseDuration(expires)
+	if err != nil {
+		return "", err
+	}
+	return time.Now().Add(d).Format(config.ISO8601), nil
+}
+
+// getVersion 获取表单或 query 中的 version, 为空时返回 0.
+func getVersion(c *fiber.Ctx) (int, error) {
+	version := strings.TrimSpace(c.FormValue("version"))
+	if version == "" {
+		version = c.Query("version")
+	}
+	if version == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(version)
+}
+
+func addShare(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	id := c.Params("id")
+	version, err1 := getVersion(c)
+	expiresAt, err2 := getExpiresAt(c)
+	if err := util.WrapErrors(err1, err2); err != nil {
+		return err
+	}
+	share := model.NewShare(id, version, expiresAt)
+	if err := db.AddShare(share); err != nil {
+		return err
+	}
+	audit(c, model.OpShareAdd, []string{id, share.Token}, "",
+		"version="+strconv.Itoa(version)+" expires="+expiresAt)
+	return c.JSON(share)
+}
+
+func getNoteShares(c *fiber.Ctx) error {
+	shares, err := db.NoteShares(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(shares)
+}
+
+func deleteShare(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	token := c.Params("token")
+	share, err := db.GetShare(token)
+	if err != nil {
+		return err
+	}
+	if err := db.DeleteShare(token); err != nil {
+		return err
+	}
+	audit(c, model.OpShareDelete, []string{share.NoteID, token},
+		"access="+strconv.Itoa(share.AccessCount), "")
+	return nil
+}
+
+func openShare(c *fiber.Ctx) (
+	share database.Share, note Note, contents string, err error) {
+
+	db.Lock()
+	defer db.Unlock()
+
+	share, note, contents, err = db.OpenShare(c.Params("token"))
+	if err == database.ErrShareNotFound {
+		err = fiber.ErrNotFound
+	}
+	return
+}
+
+// sharedNotePage 显示分享的笔记，不需要登入。
+func sharedNotePage(c *fiber.Ctx) error {
+	share, note, contents, err := openShare(c)
+	if err != nil {
+		return err
+	}
+	if trimmed := strings.TrimSpace(contents); trimmed != "" {
+		note.SetTitle(trimmed) // 固定版本的标题可能与最新版本不同
+	}
+	var buf bytes.Buffer
+	err = shareTmpl.Execute(&buf, map[string]interface{}{
+		"Token":    share.Token,
+		"Title":    note.Title,
+		"Version":  share.Version,
+		"Contents": contents,
+		"Markdown": note.Type == model.Markdown,
+	})
+	if err != nil {
+		return err
+	}
+	c.Type("html", "utf-8")
+	return c.Send(buf.Bytes())
+}
+
+func sharedNoteRaw(c *fiber.Ctx) error {
+	_, _, contents, err := openShare(c)
+	if err != nil {
+		return err
+	}
+	c.Type("txt", "utf-8")
+	return c.SendString(contents)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ahui2016/uglynotes/database"
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+// 以下函数用于查看某一时刻的笔记本 (只读)，由带有 ?at= 参数的请求调用，详见 DB.NotesAt.
+
+// getNotesAt 返回 at 时刻的笔记列表（不含内容），deleted 表示回收站中的笔记。
+func getNotesAt(c *fiber.Ctx, at time.Time, deleted bool) error {
+	all, err := db.NotesAt(at)
+	if err != nil {
+		return err
+	}
+	notes := []Note{}
+	for _, note := range all {
+		if note.Deleted == deleted {
+			note.Patches = nil
+			notes = append(notes, note)
+		}
+	}
+	return c.JSON(notes)
+}
+
+func getNoteAt(c *fiber.Ctx, at time.Time) error {
+	note, err := db.NoteAt(c.Params("id"), at)
+	if err == database.ErrNoteNotExistAt {
+		return fiber.NewError(404, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(note)
+}
+
+// getTagsAt 返回 at 时刻的全部标签，byDate 表示按创建时间排序，否则按名称排序。
+func getTagsAt(c *fiber.Ctx, at time.Time, byDate bool) error {
+	tags, err := db.TagsAt(at)
+	if err != nil {
+		return err
+	}
+	if byDate {
+		sort.SliceStable(tags, func(i, j int) bool {
+			return tags[i].CreatedAt < tags[j].CreatedAt
+		})
+	}
+	if tags == nil {
+		tags = []model.Tag{}
+	}
+	return c.JSON(tags)
+}
+
+// getNotesByTagAt 返回 at 时刻拥有该标签（或其子标签）的笔记（不含内容，不包括回收站中的笔记）。
+func getNotesByTagAt(c *fiber.Ctx, tagName string, at time.Time) error {
+	tagName, err := db.ResolveTag(tagName)
+	if err != nil {
+		return err
+	}
+	all, err := db.NotesAt(at)
+	if err != nil {
+		return err
+	}
+	notes := []Note{}
+	for _, note := range all {
+		if !note.Deleted && model.HasTagUnder(note.Tags, tagName) {
+			note.Patches = nil
+			notes = append(notes, note)
+		}
+	}
+	return c.JSON(notes)
+}
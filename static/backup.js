@@ -2,9 +2,33 @@ const export_btn = $('#export');
 const json_btn = $('#json');
 
 export_btn.click(() => {
-    ajaxGet('/api/backup/export', export_btn, () => {
+    ajaxPost(null, '/api/backup/export', export_btn, () => {
         // onSuccess
         export_btn.hide();
         json_btn.show();
     })
-});
\ No newline at end of file
+});
+const import_btn = $('#import');
+
+import_btn.click(() => {
+    const file = $('#import-file').prop('files')[0];
+    if (!file) {
+        insertErrorAlert('请选择文件');
+        return;
+    }
+    const form = new FormData();
+    form.append('file', file);
+    form.append('format', $('#import-format').val());
+    ajaxPost(form, '/api/backup/import', import_btn, (that) => {
+        // onSuccess
+        const result = that.response;
+        const imported = result.Imported ? result.Imported.length : 0;
+        insertSuccessAlert(`成功导入 ${imported} 篇笔记`);
+        for (const skipped of result.Skipped || []) {
+            insertErrorAlert(`跳过 ${skipped.Path}: ${skipped.Reason}`);
+        }
+        for (const warning of result.Warnings || []) {
+            insertInfoAlert(`${warning.Path}: ${warning.Reason}`);
+        }
+    });
+});
@@ -1,18 +1,20 @@
-const confirm_block = $('#confirm-block');
-const delete_btn = $('#delete');
-const yes_btn = $('#yes');
-const no_btn = $('#no');
 const diff = $('.diff');
 const number_input = $('#number');
 const buttons = $('#buttons');
 const export_btn = $('#export-btn');
+const restore_btn = $('#restore-btn');
+const milestone_btn = $('#milestone-btn');
+const fork_btn = $('#fork-btn');
+const compact_btn = $('#compact-btn');
 const first_btn = $('#first-btn');
 const previous_btn = $('#previous-btn');
 const next_btn = $('#next-btn');
 const last_btn = $('#last-btn');
 
+const version_info = $('#version-info');
+
 const id = getUrlParam('id');
-let note, current_n, max_n;
+let note, current_n, max_n, versions = [], milestones = [];
 
 ajaxGet('/api/note/'+id, null, that => {
   note = that.response;
@@ -25,6 +27,11 @@ ajaxGet('/api/note/'+id, null, that => {
   current_n = version_to_n(version);
   gotoHistory(current_n);
   showHistorySize(note);
+  ajaxGet(`/api/note/${id}/history`, null, that => {
+    versions = that.response;
+    showVersionInfo(current_n);
+  });
+  getMilestones();
 }, function() {
   //onloadend
   $('#loading').hide();
@@ -47,8 +54,32 @@ function showHistorySize(note) {
   $('#history-size').text(`共 ${note.Patches.length} 个历史版本，合计 ${size}`);
 }
 
+// 显示版本的创建时间（旧版本没有记录时间）。
+function showVersionInfo(n) {
+  const v = versions[n-1];
+  if (!v) {
+    version_info.text('');
+    return;
+  }
+  const createdAt = v.CreatedAt ? dayjs(v.CreatedAt).format('YYYY-MM-DD HH:mm:ss') : '时间未知';
+  let info = `${createdAt} (${fileSizeToString(v.Size)})`;
+  const m = milestones.find(m => m.Version == n);
+  if (m) {
+    info += ` ★ ${m.Label}` + (m.Protected ? ' (protected)' : '');
+  }
+  version_info.text(info);
+}
+
+function getMilestones() {
+  ajaxGet(`/api/note/${id}/milestones`, null, that => {
+    milestones = that.response;
+    showVersionInfo(current_n);
+  });
+}
+
 function gotoHistory(n) {
   current_n = n;
+  showVersionInfo(n);
   const diffString = note.Patches[n-1];
   const diffJson = Diff2Html.parse(diffString);
   const diffHtml = Diff2Html.html(diffJson, { 
@@ -112,6 +143,40 @@ function exportDownload() {
       return patched = Diff.applyPatch(patched, patch)}, "");
   insertDownloadAlert(filename, contents);
 }
+// 恢复为当前显示的历史版本（不会删除任何历史版本）
+restore_btn.click(event => {
+  event.preventDefault();
+  let form = new FormData();
+  form.append('version', current_n);
+  ajaxPost(form, `/api/note/${id}/restore`, restore_btn, that => {
+    const count = that.response.message;
+    window.location.href = `/html/history?id=${id}&version=${count}`;
+  });
+});
+
+// 以当前显示的历史版本的内容创建一篇新笔记
+fork_btn.click(event => {
+  event.preventDefault();
+  let form = new FormData();
+  form.append('version', current_n);
+  ajaxPost(form, `/api/note/${id}/fork`, fork_btn, that => {
+    window.location.href = '/html/note?id=' + that.response.message;
+  });
+});
+
+// 给当前显示的历史版本加上里程碑
+milestone_btn.click(event => {
+  event.preventDefault();
+  const label = window.prompt('里程碑名称 (留空则取消):');
+  if (!label || !label.trim()) return;
+  const isProtected = window.confirm('是否保护该版本？(压缩历史时会被保留)');
+  let form = new FormData();
+  form.append('version', current_n);
+  form.append('label', label);
+  form.append('protected', isProtected);
+  ajaxPost(form, `/api/note/${id}/milestone`, milestone_btn, getMilestones);
+});
+
 // 插入提示
 function insertDownloadAlert(filename, contents) {
   let alertElem = $('#alert-download-tmpl').contents().clone();
@@ -125,28 +190,12 @@ function insertDownloadAlert(filename, contents) {
   alertElem.insertAfter(buttons);
 }
 
-// 删除按钮
-delete_btn.click(delete_toggle);
-
-// 取消删除
-no_btn.click(delete_toggle);
-
-function delete_toggle(event) {
+// 压缩历史：合并当前显示的版本之前的全部版本（受保护的里程碑与分享链接固定的版本除外）
+compact_btn.click(event => {
   event.preventDefault();
-  delete_btn.toggle();
-  confirm_block.toggle();
-}
-
-// 确认删除
-yes_btn.click(event => {
-  let form = new FormData();
-  form.append('id', id);
-  ajaxDelete('/api/history/'+id, yes_btn, function() {
-    $('.alert').hide();
-    $('#head-buttons').hide();
-    $('#title-block').hide();
-    $('#display-options').hide();
-    $('.contents').hide();
-    insertSuccessAlert(`历史版本 id:${id} 已删除`);
+  if (!window.confirm(`合并第 ${current_n} 个版本之前的历史版本？(不可撤销)`)) return;
+  ajaxDelete(`/api/note/${id}/history?before=${current_n}`, compact_btn, that => {
+    const result = that.response;
+    window.location.href = `/html/history?id=${id}&version=${result.Versions}`;
   });
 });
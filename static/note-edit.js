@@ -270,6 +270,10 @@ function update(event) {
       let count = that.response.message;
       $('#history').attr('href', `/html/history?id=${id}&version=${count}`);
       insertSuccessAlert(`笔记内容更新，产生第 ${count} 个历史版本`);
+      const skipped = that.response.skipped;
+      if (skipped && skipped.length > 0) {
+        insertInfoAlert(`以下笔记中的链接未能更新为新标题: ${skipped.join(', ')}`);
+      }
     });
   }
 }
@@ -30,6 +30,7 @@ ajaxGet('/api/note/'+id, null, that => {
   $('#size').text(fileSizeToString(note.Size));
   edit_btn.attr('href', '/html/note/edit?id='+note.ID);
   $('#history').attr('href', '/html/history?id='+note.ID)
+  showForks(note);
 
   if (note.Deleted) {
     isDeleted = true;
@@ -72,13 +73,15 @@ ajaxGet('/api/note/'+id, null, that => {
     insertErrorAlert('复制失败，详细信息见控制台');
   });
 
+  renderContents(note, {});
   if (note.Type == 'Markdown') {
-    const dirty = marked(note.Contents);
-    const clean = DOMPurify.sanitize(dirty);
-    $('.markdown.contents').show().html(clean);
-  } else {
-    $('.plaintext.contents').show().text(note.Contents);
+    ajaxGet(`/api/note/${id}/outlinks`, null, that => {
+      const links = {};
+      that.response.forEach(link => { links[link.Target.toLowerCase()] = link; });
+      renderContents(note, links);
+    });
   }
+  showBacklinks();
 }, function() {
   //onloadend
   $('#loading').hide();
@@ -145,3 +148,50 @@ yes_btn.click(event => {
   ajaxPut(form, url, yes_btn, onDelete);
 });
 
+
+// 显示复制 (fork) 关系
+function showForks(note) {
+  if (note.ForkOf) {
+    $('#fork-of').show().find('a')
+      .text(`id:${note.ForkOf} (version ${note.ForkVersion})`)
+      .attr('href', `/html/history?id=${note.ForkOf}&version=${note.ForkVersion}`);
+  }
+  if (note.Forks && note.Forks.length > 0) {
+    const forks = $('#forks').show();
+    note.Forks.forEach(forkID => {
+      $('<a>').text('id:'+forkID).attr('href', '/html/note?id='+forkID).appendTo(forks);
+      forks.append(' ');
+    });
+  }
+}
+
+// 显示笔记内容，其中 Markdown 笔记的 [[id]] 或 [[标题]] 链接会转换为普通链接。
+function renderContents(note, links) {
+  if (note.Type == 'Markdown') {
+    const contents = note.Contents.replace(
+      /\[\[([^\[\]|\n]+)(\|[^\[\]\n]*)?\]\]/g, (m, target, alias) => {
+        const link = links[target.trim().toLowerCase()];
+        if (!link || !link.ID || link.Broken) return m;
+        const text = alias ? alias.slice(1) : target;
+        return `[${text}](/html/note?id=${link.ID})`;
+      });
+    const dirty = marked(contents);
+    const clean = DOMPurify.sanitize(dirty);
+    $('.markdown.contents').show().html(clean);
+  } else {
+    $('.plaintext.contents').show().text(note.Contents);
+  }
+}
+
+// 显示链接到本笔记的笔记
+function showBacklinks() {
+  ajaxGet(`/api/note/${id}/backlinks`, null, that => {
+    const notes = that.response;
+    if (notes.length == 0) return;
+    const backlinks = $('#backlinks').show();
+    notes.forEach(n => {
+      backlinks.append(' ');
+      $('<a>').text(n.Title).attr('href', '/html/note?id='+n.ID).appendTo(backlinks);
+    });
+  });
+}
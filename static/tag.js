@@ -9,7 +9,7 @@ const check_tags_btn = $('#check-tags-btn');
 const tag_name = getUrlParam('name');
 tagName.text(tag_name);
 
-ajaxGet(`/api/tag/${tag_name}/notes`, null, that => {
+ajaxGet(`/api/tag/${encodeURIComponent(tag_name)}/notes`, null, that => {
   $('#tag-name').show();
   $('#count-block').show();
   const notes = that.response;
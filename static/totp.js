@@ -0,0 +1,59 @@
+const status_elem = $('#status');
+const enroll_btn = $('#enroll');
+const confirm_btn = $('#confirm');
+const disable_btn = $('#disable');
+const code_form = $('#code-form');
+const code_input = $('#code');
+
+ajaxGet('/api/totp', null, that => {
+  const tf = that.response;
+  if (tf.enabled) {
+    status_elem.text(`两步验证已启用，剩余 ${tf.recoveryCodes} 个恢复码。`);
+    confirm_btn.hide();
+    disable_btn.show();
+    code_form.show();
+    return;
+  }
+  status_elem.text('两步验证未启用。');
+  if (tf.required) {
+    insertErrorAlert('本站要求启用两步验证，启用后才能正常使用。');
+  }
+  enroll_btn.show();
+});
+
+enroll_btn.click(() => {
+  ajaxPost(null, '/api/totp/enroll', enroll_btn, that => {
+    const result = that.response;
+    $('#uri').text(result.uri);
+    $('#secret').text(result.secret);
+    $('#recovery-codes').text(result.recoveryCodes.join('\n'));
+    $('#secret-block').show();
+    enroll_btn.hide();
+    code_form.show();
+    code_input.focus();
+  });
+});
+
+function submitCode(url, btn, msg) {
+  const code = code_input.val().trim();
+  if (code == '') {
+    code_input.focus();
+    return;
+  }
+  const form = new FormData();
+  form.append('code', code);
+  ajaxPost(form, url, btn, () => {
+    code_form.hide();
+    insertSuccessAlert(msg);
+  });
+}
+
+confirm_btn.click(event => {
+  event.preventDefault();
+  submitCode('/api/totp/confirm', confirm_btn, '两步验证已启用');
+});
+
+disable_btn.click(event => {
+  event.preventDefault();
+  submitCode('/api/totp/disable', disable_btn, '两步验证已停用');
+});
@@ -36,8 +36,11 @@ CREATE TABLE IF NOT EXISTS note_tag
 
 CREATE TABLE IF NOT EXISTS patch
 (
-  id      text    PRIMARY KEY,
-  diff    text    NOT NULL
+  id          text    PRIMARY KEY,
+  diff        text    NOT NULL,
+  created_at  text    NOT NULL DEFAULT '',
+  size        int     NOT NULL DEFAULT 0,
+  session     text    NOT NULL DEFAULT ''
 );
 
 CREATE TABLE IF NOT EXISTS note_patch
@@ -109,7 +112,20 @@ const GetTagID = `SELECT id FROM tag WHERE name=?;`
 const InsertTag = `INSERT INTO tag (id, name, created_at) VALUES (?, ?, ?);`
 const InsertNoteTag = `INSERT INTO note_tag (note_id, tag_id) VALUES (?, ?);`
 
-const InsertPatch = `INSERT INTO patch (id, diff) VALUES (?, ?);`
+const InsertPatch = `INSERT INTO patch (id, diff, created_at, size, session)
+    VALUES (?, ?, ?, ?, ?);`
+
+// PatchColumns 用于检查旧的 patch 表是否缺少新增的列。
+const PatchColumns = `SELECT name FROM pragma_table_info('patch');`
+
+// AddPatchColumns 为旧的 patch 表添加新增的列，每项是 {列名, 语句}。
+var AddPatchColumns = [][2]string{
+	{"created_at", `ALTER TABLE patch ADD COLUMN created_at text NOT NULL DEFAULT '';`},
+	{"size", `ALTER TABLE patch ADD COLUMN size int NOT NULL DEFAULT 0;`},
+	{"session", `ALTER TABLE patch ADD COLUMN session text NOT NULL DEFAULT '';`},
+}
+
+const CreatePatchIndex = `CREATE INDEX IF NOT EXISTS idx_patch_create ON patch(created_at);`
 const InsertNotePatch = `INSERT INTO note_patch (note_id, patch_id) VALUES (?, ?);`
 
 const InsertFile = `INSERT INTO file (
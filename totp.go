@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/ahui2016/uglynotes/model"
+	"github.com/ahui2016/uglynotes/totp"
+	"github.com/gofiber/fiber/v2"
+)
+
+const totpIssuer = "uglynotes"
+
+func totpPage(c *fiber.Ctx) error {
+	return c.SendFile("./static/totp.html")
+}
+
+// checkTwoFactor 在密码正确后调用，如果已启用两步验证则检查验证码（或恢复码），
+// 但不使验证码失效（详见 consumeTwoFactor）。未启用两步验证时返回空字符串。
+func checkTwoFactor(c *fiber.Ctx) (string, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	if !db.TwoFactorEnabled() {
+		return "", nil
+	}
+	code, err := getFormValue(c, "code")
+	if err != nil {
+		return "", fiber.NewError(400, "Require 2FA Code")
+	}
+	ok, err := db.CheckTwoFactor(code)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		passwordTry++
+		if err := checkPasswordTry(c); err != nil {
+			return "", err
+		}
+		return "", fiber.NewError(400, "Wrong 2FA Code")
+	}
+	return code, nil
+}
+
+// consumeTwoFactor 在登入的其他检查都通过后调用，使 checkTwoFactor 检查过的验证码失效。
+// 如果验证码在此期间已被使用（例如同时登入），则登入失败。
+func consumeTwoFactor(code string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	ok, err := db.VerifyTwoFactor(code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fiber.NewError(400, "Wrong 2FA Code")
+	}
+	return nil
+}
+
+func twoFactorStatus(c *fiber.Ctx) error {
+	tf, err := db.GetTwoFactor()
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"enabled":       tf.Enabled,
+		"required":      config.Require2FA,
+		"verified":      db.SessionTwoFactor(c),
+		"recoveryCodes": len(tf.RecoveryCodes),
+	})
+}
+
+func enrollTwoFactor(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	secret, codes, err := db.EnrollTwoFactor()
+	if err != nil {
+		return err
+	}
+	audit(c, model.OpTwoFactorEnroll, nil, "", "")
+	return c.JSON(fiber.Map{
+		"secret":        secret,
+		"uri":           totp.URI(secret, totpIssuer, totpIssuer),
+		"recoveryCodes": codes,
+	})
+}
+
+func confirmTwoFactor(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	code, err := getFormValue(c, "code")
+	if err != nil {
+		return err
+	}
+	if err := db.ConfirmTwoFactor(code); err != nil {
+		return err
+	}
+	audit(c, model.OpTwoFactorConfirm, nil, "enabled=false", "enabled=true")
+	return db.SessionSetTwoFactor(c)
+}
+
+func disableTwoFactor(c *fiber.Ctx) error {
+	db.Lock()
+	defer db.Unlock()
+
+	if config.Require2FA {
+		return errors.New("两步验证是必须的 (settings: Require2FA)")
+	}
+	code, err := getFormValue(c, "code")
+	if err != nil {
+		return err
+	}
+	ok, err := db.VerifyTwoFactor(code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("Wrong 2FA Code")
+	}
+	if err := db.DisableTwoFactor(); err != nil {
+		return err
+	}
+	audit(c, model.OpTwoFactorDisable, nil, "enabled=true", "enabled=false")
+	return nil
+}
@@ -0,0 +1,79 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret 是 RFC 6238 附录 B 中的 SHA1 密钥 "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// RFC 6238 附录 B 的验证码取最后 6 位。
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeNormalizeSecret(t *testing.T) {
+	want, _ := Code(rfcSecret, 1)
+	got, err := Code("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", 1)
+	if err != nil || got != want {
+		t.Errorf("Code(lower case with spaces) = %q, %v, want %q", got, err, want)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code(invalid secret) should fail")
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	counter := Counter(now)
+	for i := int64(-Skew); i <= Skew; i++ {
+		code, _ := Code(rfcSecret, counter+i)
+		got, ok := Validate(rfcSecret, " "+code+" ", now)
+		if !ok || got != counter+i {
+			t.Errorf("Validate(window %+d) = %d, %t, want %d, true", i, got, ok, counter+i)
+		}
+	}
+	for _, i := range []int64{-Skew - 1, Skew + 1} {
+		code, _ := Code(rfcSecret, counter+i)
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate(window %+d) should fail", i)
+		}
+	}
+}
+
+func TestValidateBadCode(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870821", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate(%q) should fail", code)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes := NewRecoveryCodes(3)
+	if len(codes) != 3 || len(codes[0]) != 11 || codes[0][5] != '-' {
+		t.Fatalf("NewRecoveryCodes(3) = %v", codes)
+	}
+	if HashRecoveryCode(" "+codes[0]+" ") != HashRecoveryCode(codes[0]) {
+		t.Error("HashRecoveryCode should ignore surrounding spaces")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/ahui2016/uglynotes/model"
 	"github.com/gofiber/fiber/v2"
@@ -30,14 +31,17 @@ func getNoteType(c *fiber.Ctx) (NoteType, error) {
 	return noteType, err
 }
 
+// getTags 会把别名替换为对应的标签 (详见 DB.ResolveTags)。
 func getTags(c *fiber.Ctx) ([]string, error) {
 	tagsString, err := getFormValue(c, "tags")
 	if err != nil {
 		return nil, err
 	}
 	var tags []string
-	err = json.Unmarshal([]byte(tagsString), &tags)
-	return tags, err
+	if err = json.Unmarshal([]byte(tagsString), &tags); err != nil {
+		return nil, err
+	}
+	return db.ResolveTags(tags)
 }
 
 func getProtected(c *fiber.Ctx) (protected bool, err error) {
@@ -67,3 +71,39 @@ func getTagGroup(c *fiber.Ctx) ([]string, error) {
 	tagsString, err := getParams(c, "tags")
 	return strings.Split(tagsString, " "), err
 }
+
+// dateLayout 只有日期的时间格式。
+const dateLayout = "2006-01-02"
+
+// parseTimeParam 解析 ISO8601 / RFC3339 格式的时间，或只有日期 (当天零点，本地时间)。
+func parseTimeParam(value string) (time.Time, error) {
+	for _, layout := range []string{config.ISO8601, time.RFC3339, dateLayout} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fiber.NewError(400, "cannot parse time: "+value)
+}
+
+// getAt 获取 query 中的 at (时间点)，只写日期时表示当天结束时。
+// 没有 at 参数时 ok 为 false.
+func getAt(c *fiber.Ctx) (at time.Time, ok bool, err error) {
+	at, err = getTimeQuery(c, "at", true)
+	return at, !at.IsZero(), err
+}
+
+// getTimeQuery 获取 query 中的时间，为空时返回零值。
+// 只写日期时表示当天零点，如果 endOfDay 为 true 则表示当天结束时。
+func getTimeQuery(c *fiber.Ctx, key string, endOfDay bool) (t time.Time, err error) {
+	value := strings.TrimSpace(c.Query(key))
+	if value == "" {
+		return
+	}
+	if t, err = parseTimeParam(value); err != nil {
+		return
+	}
+	if endOfDay && len(value) == len(dateLayout) {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}